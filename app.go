@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,73 +24,270 @@ import (
 
 type App struct {
 	*ssh.Server
+	*Registry
 
-	progs map[string]*tea.Program
+	// cfgMu guards cfg, which is replaced on reload
+	cfgMu sync.RWMutex
+	cfg   Config
 
-	playerToRoom map[string]*Room
-	roomRepo     RoomRepository
-	tableRepo    ArithmeticTableRepository
+	players     PlayerRepository
+	matches     MatchRepository
+	matchmaker  *Matchmaker
+	connLimiter *RateLimiter
+	roomLimiter *RateLimiter
+
+	// set once the server is stopping, no new sessions, rooms nor matches
+	// are started
+	draining atomic.Bool
 }
 
-func NewApp() *App {
-	app := App{
-		progs:        make(map[string]*tea.Program),
-		playerToRoom: make(map[string]*Room),
-		roomRepo:     NewInMemoryRoomRepository(),
-		tableRepo:    NewInMemoryArithmeticTableRepository(),
+var errDraining = errors.New("server is restarting, try again later")
+
+// NewApp returns an App serving cfg over SSH.
+func NewApp(cfg Config) *App {
+	applyTheme(cfg.Theme)
+
+	app, err := newApp(cfg)
+	if err != nil {
+		log.Fatal("Could not start app", "error", err)
 	}
 
-	s, err := wish.NewServer(
-		wish.WithAddress(net.JoinHostPort(host, port)),
-		wish.WithHostKeyPath(".ssh/id_ed25519"),
+	opts := []ssh.Option{wish.WithAddress(cfg.Addr()), ssh.WrapConn(app.limitConn)}
+	for _, path := range cfg.HostKeys {
+		opts = append(opts, wish.WithHostKeyPath(path))
+	}
+	opts = append(opts,
 		wish.WithPublicKeyAuth(func(_ ssh.Context, key ssh.PublicKey) bool {
-			return true
+			return app.Config().Access.Allowed(cryptoSsh.FingerprintSHA256(key))
 		}),
 		wish.WithMiddleware(
 			bubbletea.MiddlewareWithProgramHandler(app.ProgramHandler, termenv.ANSI256),
+			app.adminMiddleware,
 			logging.Middleware(),
 		),
 	)
+	s, err := wish.NewServer(opts...)
 
 	if err != nil {
 		log.Fatal("Could not start server", "error", err)
 	}
 
 	app.Server = s
-	return &app
+	return app
+}
+
+// newApp returns an App of cfg without SSH server.
+func newApp(cfg Config) (*App, error) {
+	players, err := NewJSONPlayerRepository(cfg.PlayersPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not load players: %w", err)
+	}
+
+	matches, err := NewJSONLinesMatchRepository(cfg.MatchesPath())
+	if err != nil {
+		return nil, fmt.Errorf("could not load matches: %w", err)
+	}
+
+	app := &App{
+		Registry:    NewRegistry(cfg.Limits.MaxRooms),
+		cfg:         cfg,
+		players:     players,
+		matches:     matches,
+		matchmaker:  NewMatchmaker(),
+		connLimiter: NewRateLimiter(time.Minute),
+		roomLimiter: NewRateLimiter(time.Minute),
+	}
+	app.roomRepo.Subscribe(app.publishRoomEvent)
+	return app, nil
+}
+
+func (app *App) Config() Config {
+	app.cfgMu.RLock()
+	defer app.cfgMu.RUnlock()
+
+	return app.cfg
+}
+
+// Reload loads the config again and applies it to new sessions and rooms,
+// running sessions keep going. Settings read only on start are kept as they
+// are. An invalid config is rejected and the current one stays.
+func (app *App) Reload() ([]ConfigChange, error) {
+	app.cfgMu.Lock()
+	defer app.cfgMu.Unlock()
+
+	cfg, err := app.cfg.Reload()
+	if err != nil {
+		log.Error("Rejected config reload", "error", err)
+		return nil, err
+	}
+
+	changes := make([]ConfigChange, 0)
+	for _, c := range diffConfig(app.cfg, cfg) {
+		if restartOnly[c.Key] {
+			log.Warn("Config change needs a restart, ignored", "key", c.Key, "old", c.Old, "new", c.New)
+			continue
+		}
+		log.Info("Config changed", "key", c.Key, "old", c.Old, "new", c.New)
+		changes = append(changes, c)
+	}
+	cfg.keepRestartOnly(app.cfg)
+
+	app.cfg = cfg
+	applyTheme(cfg.Theme)
+	log.Info("Reloaded config", "changes", len(changes))
+	return changes, nil
+}
+
+// limitConn drops connections of remote addresses over the rate limit.
+func (app *App) limitConn(_ ssh.Context, conn net.Conn) net.Conn {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if !app.connLimiter.Allow(addr, app.Config().RateLimits.ConnectionsPerMinute, time.Now()) {
+		log.Warn("Too many connections", "addr", addr)
+		return nil
+	}
+	return conn
+}
+
+// adminMiddleware runs `ssh ... admin <command>` for admins instead of the
+// game.
+func (app *App) adminMiddleware(next ssh.Handler) ssh.Handler {
+	return func(sess ssh.Session) {
+		cmd := sess.Command()
+		if len(cmd) == 0 || cmd[0] != "admin" {
+			next(sess)
+			return
+		}
+
+		user := cryptoSsh.FingerprintSHA256(sess.PublicKey())
+		if !app.Config().Access.IsAdmin(user) {
+			wish.Fatalln(sess, "permission denied")
+			return
+		}
+
+		switch {
+		case len(cmd) == 2 && cmd[1] == "reload":
+			log.Info("Reload requested", "admin", user)
+			changes, err := app.Reload()
+			if err != nil {
+				wish.Fatalln(sess, err)
+				return
+			}
+			for _, c := range changes {
+				wish.Println(sess, c)
+			}
+			wish.Printf(sess, "reloaded, %d changes\n", len(changes))
+		default:
+			wish.Fatalln(sess, "usage: admin reload")
+		}
+	}
 }
 
 func (app *App) Start() {
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	log.Info("Starting SSH server", "host", host, "port", port)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("Reload requested by SIGHUP")
+			app.Reload()
+		}
+	}()
+
+	cfg := app.Config()
+	log.Info("Starting SSH server", "host", cfg.Host, "port", cfg.Port)
 	go func() {
 		if err := app.Server.ListenAndServe(); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
 			log.Error("Could not start server", "error", err)
 			done <- nil
 		}
 	}()
+	go app.runMatchmaker()
 
 	<-done
+	app.Drain(done)
 	log.Info("Stopping SSH server")
+	for _, prog := range app.allSessions() {
+		prog.Quit()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			log.Warn("Stop requested again, closing connections")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 	if err := app.Server.Shutdown(ctx); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
 		log.Error("Could not stop server", "error", err)
 	}
 }
 
-func (app *App) Send(player string, msg tea.Msg) {
-	if room, exists := app.playerToRoom[player]; exists {
-		for _, p := range room.players {
-			go app.progs[p].Send(msg)
+// Drain stops accepting sessions, rooms and matches, tells every session the
+// server is restarting and waits for running matches to end. Matches still
+// running after the drain timeout, or when stop receives another signal, are
+// recorded as aborted.
+func (app *App) Drain(stop <-chan os.Signal) {
+	app.draining.Store(true)
+
+	timeout := app.Config().DrainTimeout.Duration
+	deadline := time.Now().Add(timeout)
+	log.Info("Draining", "timeout", timeout)
+	for _, prog := range app.allSessions() {
+		go prog.Send(ServerDraining{deadline: deadline})
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+wait:
+	for time.Now().Before(deadline) && len(app.runningRooms()) > 0 {
+		select {
+		case <-stop:
+			log.Warn("Stop requested again, skipping the drain")
+			break wait
+		case <-ticker.C:
 		}
+	}
+
+	for _, r := range app.runningRooms() {
+		log.Warn("Aborting match", "room", r.id)
+		app.abortMatch(r)
+	}
+}
+
+// runningRooms returns rooms counting down or playing.
+func (app *App) runningRooms() []*Room {
+	rooms := make([]*Room, 0)
+	for _, r := range app.roomRepo.List() {
+		if status := r.Status(); status == RoomCountdown || status == RoomPlaying {
+			rooms = append(rooms, r)
+		}
+	}
+	return rooms
+}
+
+func (app *App) Send(player string, msg tea.Msg) {
+	if room, exists := app.RoomOf(player); exists {
+		app.broadcast(room, msg)
 	} else {
 		log.Errorf("user not found: %s", player)
 		// TODO: error handling
 	}
 }
 
+func (app *App) broadcast(room *Room, msg tea.Msg) {
+	for _, p := range room.Audience() {
+		if prog, exists := app.Session(p); exists {
+			go prog.Send(msg)
+		}
+	}
+}
+
 func (app *App) ProgramHandler(sess ssh.Session) *tea.Program {
 	_, _, active := sess.Pty()
 	if !active {
@@ -96,41 +296,651 @@ func (app *App) ProgramHandler(sess ssh.Session) *tea.Program {
 	}
 
 	user := cryptoSsh.FingerprintSHA256(sess.PublicKey())
-
-	if _, exist := app.progs[user]; exist {
-		wish.Fatalln(sess, "user has another session")
+	if err := app.admit(user); err != nil {
+		wish.Fatalln(sess, err)
+		return nil
 	}
 
-	m := NewAppModel(user, app)
+	// `ssh ... join CODE` joins a room right away
+	joinCode := ""
+	if cmd := sess.Command(); len(cmd) == 2 && cmd[0] == "join" {
+		joinCode = cmd[1]
+	}
 
-	// route to room page
-	gm := NewRoomPage(30, 80, app.roomRepo)
-	m.router.Goto(StaticRoute{Model: gm})
+	opts := append(bubbletea.MakeOptions(sess), tea.WithAltScreen())
+	session := app.openSession(user, joinCode, sess.Context().Done(), opts...)
 
 	// listen to connection close
 	go func() {
 		ctx := sess.Context()
 		<-ctx.Done()
 
-		// release user resource
-		delete(app.progs, user)
+		app.Disconnect(session)
+		log.Infof("Good bye %s", user)
+	}()
 
-		if r, exists := app.playerToRoom[user]; exists {
-			r.RemovePlayer(user)
-			if len(r.players) == 0 {
-				app.roomRepo.Remove(r.id)
-			}
+	return session.prog
+}
+
+// admit reports why a new session of user is refused, if it is. A player
+// dropped from a running match may still come back while the server drains,
+// the drain waits for that match anyway.
+func (app *App) admit(user string) error {
+	if app.draining.Load() && !app.IsAway(user) {
+		return errDraining
+	}
+	if app.SessionCount() >= app.Config().Limits.MaxSessions {
+		return errors.New("server is full, try again later")
+	}
+	return nil
+}
+
+// openSession adds a session of user whose program is not running yet. A key
+// having a session already is handled by the duplicate session policy.
+func (app *App) openSession(user, joinCode string, done <-chan struct{}, opts ...tea.ProgramOption) *Session {
+	session := &Session{user: user}
+	m := NewAppModel(session, app, done)
+
+	// a player dropped from a running match gets its slot back
+	app.Reconnect(user)
+
+	_, duplicate := app.Session(user)
+	policy := app.Config().DuplicateSessions
+	if duplicate && policy == SessionAsk {
+		m.router.Goto(StaticRoute{Model: NewSessionChoicePage(session, joinCode)})
+	} else {
+		m.router.Goto(StaticRoute{Model: app.landing(user, joinCode)})
+	}
+
+	session.prog = tea.NewProgram(m, opts...)
+	// the new session is added first, so the player is never seen without
+	// one while the others quit
+	app.AddSession(session)
+	if duplicate && policy == SessionTakeover {
+		app.TakeOver(session)
+	}
+	return session
+}
+
+// landing returns the first page of a session of user. Players in a room go
+// straight back to it, new players pick a nickname first. Others join the
+// room of joinCode if given.
+func (app *App) landing(user, joinCode string) tea.Model {
+	if r, exists := app.RoomOf(user); exists && r.Status() != RoomFinished {
+		gm := NewGameModel()
+		gm.resume = true
+		return &gm
+	}
+	if _, exists := app.players.Find(user); exists {
+		page := NewRoomPage(30, 80, app)
+		// the router sets the user too late for joining right away
+		page.user = user
+		if joinCode != "" {
+			page.initCmd = page.joinByCode(joinCode)
 		}
+		return page
+	}
+	return NewOnboardingPage(joinCode)
+}
 
-		delete(app.playerToRoom, user)
+// TakeOver makes every other session of the user of keep quit, the player
+// itself stays where it is.
+func (app *App) TakeOver(keep *Session) {
+	sessions, _ := app.Session(keep.user)
+	for _, s := range sessions {
+		if s != keep {
+			log.Infof("session of %s taken over", keep.user)
+			go s.prog.Send(SessionTakenOver{})
+		}
+	}
+}
 
-		app.tableRepo.RemoveByPlayer(user)
+// publishRoomEvent forwards changes of listed rooms to everyone on the room
+// list. It is called with the room locked, so it only hands messages over.
+func (app *App) publishRoomEvent(e RoomEvent) {
+	if !e.Room.listed() {
+		return
+	}
+	for _, prog := range app.roomWatcherSessions() {
+		go prog.Send(RoomListChanged{event: e})
+	}
+}
 
-		log.Infof("Good bye %s", user)
-	}()
+// CreateRoom adds a room with opts, its matches keep the duration and penalty
+// of the current config.
+func (app *App) CreateRoom(opts RoomOptions) (*Room, error) {
+	if app.draining.Load() {
+		return nil, errDraining
+	}
+
+	cfg := app.Config()
+	opts.Penalty = cfg.Penalty
+	opts.Duration = cfg.GameDuration.Duration
+	room, err := app.roomRepo.Create(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add room: %w", err)
+	}
 
-	prog := tea.NewProgram(m, append(bubbletea.MakeOptions(sess), tea.WithAltScreen())...)
-	app.progs[user] = prog
+	log.Infof("add new room: %d (%s), seed: %d", room.id, room.mode, room.seed)
+	return room, nil
+}
 
-	return prog
+// HostRoom creates a room with opts and joins player to it as the host.
+func (app *App) HostRoom(player string, opts RoomOptions) (*Room, error) {
+	if !app.roomLimiter.Allow(player, app.Config().RateLimits.RoomsPerMinute, time.Now()) {
+		return nil, fmt.Errorf("too many rooms hosted, try again later")
+	}
+
+	room, err := app.CreateRoom(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := app.JoinRoom(player, room); err != nil {
+		app.roomRepo.Remove(room.id)
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// Disconnect releases the resources of a user once its last session is
+// closed. Players in a running match keep their slot for the reconnect grace
+// window, others leave their room right away.
+func (app *App) Disconnect(session *Session) {
+	user := session.user
+	if !app.RemoveSession(session) {
+		// other sessions of the user go on
+		return
+	}
+	app.matchmaker.Dequeue(user)
+
+	grace := app.Config().ReconnectGrace.Duration
+	r, away := app.disconnect(user, grace, func() {
+		if app.expireAway(user) {
+			log.Infof("player %s did not reconnect", user)
+			app.LeaveRoom(user)
+		}
+	})
+	if !away {
+		app.LeaveRoom(user)
+		return
+	}
+
+	log.Infof("player %s dropped from room %d, waiting %s to reconnect", user, r.id, grace)
+	app.broadcast(r, PlayerAway{user: user, away: true})
+}
+
+// Reconnect gives user its slot back after a dropped connection, it reports
+// whether its match is still running and can be resumed.
+func (app *App) Reconnect(user string) (*Room, bool) {
+	r, exists := app.reconnect(user)
+	if !exists {
+		return nil, false
+	}
+
+	app.broadcast(r, PlayerAway{user: user, away: false})
+	if status := r.Status(); status != RoomCountdown && status != RoomPlaying {
+		// the match ended meanwhile, there is nothing to resume
+		app.LeaveRoom(user)
+		return nil, false
+	}
+
+	log.Infof("player %s reconnected to room %d", user, r.id)
+	return r, true
+}
+
+// LeaveRoom removes player from its room and releases its table, the room is
+// removed once it is empty. Spectators simply stop watching.
+func (app *App) LeaveRoom(player string) {
+	app.leaveRoom(player, Leave{user: player})
+}
+
+// leaveRoom removes player from its room and sends self to every session of
+// the player, so views other than the acting one follow.
+func (app *App) leaveRoom(player string, self tea.Msg) {
+	r, backToWaiting, orphans := app.leave(player)
+	for _, s := range orphans {
+		if prog, exists := app.Session(s); exists {
+			go prog.Send(RoomClosed{})
+		}
+	}
+	if r == nil {
+		return
+	}
+
+	app.broadcast(r, Leave{user: player})
+	// other views of the player are still in the room
+	if sessions, exists := app.Session(player); exists {
+		go sessions.Send(self)
+	}
+	if backToWaiting {
+		app.broadcastStatus(r)
+	}
+
+	// bots do not play on their own
+	players := r.Players()
+	for _, p := range players {
+		if !isBot(p) {
+			return
+		}
+	}
+	for _, p := range players {
+		app.LeaveRoom(p)
+	}
+}
+
+// Kick removes target from the room hosted by host, it can not join the room
+// again.
+func (app *App) Kick(host, target string) error {
+	r, err := app.kick(host, target)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s kicks %s from room %d", host, target, r.id)
+	// every view of target is told it was kicked, a Leave racing the notice
+	// would replace it
+	app.leaveRoom(target, Kicked{})
+	return nil
+}
+
+// JoinByCode adds player to the room with the invite code.
+func (app *App) JoinByCode(player, code string) (*Room, error) {
+	r := app.roomRepo.FindByCode(code)
+	if r == nil {
+		return nil, fmt.Errorf("no room with code %s", code)
+	}
+	if _, err := app.JoinRoom(player, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AddBot fills a slot of r with a bot of the given skill.
+func (app *App) AddBot(r *Room, skill BotSkill) error {
+	bot := NewBot(app, skill)
+	if _, err := app.JoinRoom(bot.id, r); err != nil {
+		return err
+	}
+
+	log.Infof("bot %s joins room %d", bot.id, r.id)
+	go bot.run()
+	return nil
+}
+
+// Rematch records that player wants to play again, once every player in the
+// room agrees the tables are regenerated and the new match is started.
+func (app *App) Rematch(player string) error {
+	if app.draining.Load() {
+		return errDraining
+	}
+
+	r, round, err := app.rematch(player)
+	if err != nil {
+		return err
+	}
+
+	if round == 0 {
+		app.broadcast(r, RematchRequested{user: player})
+		return nil
+	}
+
+	log.Infof("rematch in room %d", r.id)
+	app.broadcast(r, Rematch{})
+	app.broadcastStatus(r)
+	go app.runCountdown(r, round)
+	return nil
+}
+
+// Spectate lets user watch the match in r read-only.
+func (app *App) Spectate(user string, r *Room) error {
+	if err := app.spectate(user, r); err != nil {
+		return err
+	}
+
+	log.Infof("user %s spectates room %d", user, r.id)
+	return nil
+}
+
+// JoinRoom adds player to r and creates its table, the ready-check begins
+// once the room is full.
+func (app *App) JoinRoom(player string, r *Room) (int, error) {
+	index, full, err := app.join(player, r)
+	if err != nil {
+		return 0, err
+	}
+
+	app.broadcast(r, Join{user: player, index: index})
+	if full {
+		app.broadcastStatus(r)
+	}
+
+	return index, nil
+}
+
+// Ready marks player as ready, the countdown starts once everyone is ready.
+func (app *App) Ready(player string) error {
+	if app.draining.Load() {
+		return errDraining
+	}
+
+	r, exists := app.RoomOf(player)
+	if !exists {
+		return fmt.Errorf("player %s is not in a room", player)
+	}
+
+	r.mu.Lock()
+	if r.status != RoomReadyCheck {
+		r.mu.Unlock()
+		return fmt.Errorf("room %d is not in ready-check", r.id)
+	}
+	round := 0
+	if r.setReady(player) {
+		round = r.beginCountdown()
+	}
+	r.mu.Unlock()
+
+	app.broadcast(r, Ready{user: player})
+	if round != 0 {
+		app.broadcastStatus(r)
+		go app.runCountdown(r, round)
+	}
+
+	return nil
+}
+
+func (app *App) broadcastStatus(r *Room) {
+	r.mu.Lock()
+	msg := r.statusChanged()
+	r.mu.Unlock()
+
+	app.broadcast(r, msg)
+}
+
+func (app *App) runCountdown(r *Room, round int) {
+	for n := countdownFrom; n > 0; n-- {
+		r.mu.Lock()
+		current := r.inRound(RoomCountdown, round)
+		if current {
+			r.countdown = n
+		}
+		r.mu.Unlock()
+
+		if !current {
+			return
+		}
+		app.broadcast(r, Countdown{n: n})
+		time.Sleep(time.Second)
+	}
+
+	app.startMatch(r, round)
+}
+
+// startMatch starts the clock of r, the match ends when the server reaches
+// the deadline no matter what clients think.
+func (app *App) startMatch(r *Room, round int) {
+	r.mu.Lock()
+	if !r.inRound(RoomCountdown, round) {
+		r.mu.Unlock()
+		return
+	}
+	r.startedAt = time.Now()
+	duration := r.duration
+	r.deadline = r.startedAt.Add(duration)
+	r.setStatus(RoomPlaying)
+	msg := r.statusChanged()
+	r.mu.Unlock()
+
+	app.broadcast(r, msg)
+
+	time.AfterFunc(duration, func() {
+		app.finishMatch(r, round)
+	})
+}
+
+// finishMatch ends the match of round in r and records it to the profiles of
+// its players.
+func (app *App) finishMatch(r *Room, round int) {
+	r.mu.Lock()
+	if !r.inRound(RoomPlaying, round) {
+		r.mu.Unlock()
+		return
+	}
+	r.setStatus(RoomFinished)
+	msg := r.statusChanged()
+	players := append([]string(nil), r.players...)
+	r.mu.Unlock()
+
+	result := app.collectResult(players, msg.seed, msg.deadline.Sub(msg.startedAt))
+	if r.practice() {
+		app.recordPractice(r.practiceKey(), result)
+	} else {
+		app.recordMatch(result)
+	}
+
+	app.broadcast(r, msg)
+}
+
+// abortMatch ends the match in r before its deadline. Partial scores are
+// kept as an aborted match, they change neither profiles nor ratings.
+func (app *App) abortMatch(r *Room) {
+	r.mu.Lock()
+	if r.status != RoomCountdown && r.status != RoomPlaying {
+		r.mu.Unlock()
+		return
+	}
+	// a new round stops the countdown and the clock of the match
+	r.round++
+	playing := r.status == RoomPlaying
+	r.setStatus(RoomFinished)
+	msg := r.statusChanged()
+	players := append([]string(nil), r.players...)
+	r.mu.Unlock()
+
+	if playing && !r.practice() && !anyBot(players) {
+		result := app.collectResult(players, msg.seed, time.Since(msg.startedAt))
+		result.aborted = true
+		if err := app.matches.Add(NewMatchRecord(result, time.Now())); err != nil {
+			log.Warn("failed to save match", "error", err)
+		}
+	}
+
+	app.broadcast(r, msg)
+}
+
+// collectResult reads the scores of players from their tables.
+func (app *App) collectResult(players []string, seed int64, duration time.Duration) MatchResult {
+	result := MatchResult{
+		players:  make([]string, 0, len(players)),
+		scores:   make([]int, 0, len(players)),
+		duration: duration,
+		seed:     seed,
+	}
+	for _, p := range players {
+		if t := app.tableRepo.FindByPlayer(p); t != nil {
+			result.add(p, t)
+		}
+	}
+	return result
+}
+
+func (app *App) recordMatch(result MatchResult) {
+	// matches against bots count for profiles but not for ratings nor
+	// leaderboards
+	if anyBot(result.players) {
+		app.recordBotMatch(result)
+		return
+	}
+
+	if err := app.matches.Add(NewMatchRecord(result, time.Now())); err != nil {
+		log.Warn("failed to save match", "error", err)
+	}
+
+	ratings := make([]float64, len(result.players))
+	for i, p := range result.players {
+		ratings[i] = app.Rating(p)
+	}
+	deltas := EloDeltas(ratings, result.scores)
+
+	winners := result.Winners()
+	for i, p := range result.players {
+		score := result.scores[i]
+		won := len(winners) == 1 && winners[0] == p
+		lost := len(winners) == 1 && !won
+		rating := ratings[i] + deltas[i]
+		err := app.players.Update(p, func(player *Player) {
+			player.Record(score, won, lost)
+			player.Rating = rating
+		})
+		if err != nil {
+			log.Warn("failed to record match", "player", p, "error", err)
+		}
+	}
+}
+
+func (app *App) recordBotMatch(result MatchResult) {
+	winners := result.Winners()
+	for i, p := range result.players {
+		if isBot(p) {
+			continue
+		}
+
+		score := result.scores[i]
+		won := len(winners) == 1 && winners[0] == p
+		lost := len(winners) == 1 && !won
+		err := app.players.Update(p, func(player *Player) {
+			player.Record(score, won, lost)
+		})
+		if err != nil {
+			log.Warn("failed to record match", "player", p, "error", err)
+		}
+	}
+}
+
+// recordPractice keeps personal bests, practice does not count towards
+// ratings nor leaderboards.
+func (app *App) recordPractice(key string, result MatchResult) {
+	for i, p := range result.players {
+		score := result.scores[i]
+		err := app.players.Update(p, func(player *Player) {
+			player.RecordPractice(key, score)
+		})
+		if err != nil {
+			log.Warn("failed to record practice", "player", p, "error", err)
+		}
+	}
+}
+
+// PracticeBest returns the personal best of user for key.
+func (app *App) PracticeBest(user, key string) (int, bool) {
+	p, exists := app.players.Find(user)
+	if !exists {
+		return 0, false
+	}
+	best, exists := p.PracticeBests[key]
+	return best, exists
+}
+
+// Rating returns the rating of user, players without a profile have the
+// initial one.
+func (app *App) Rating(user string) float64 {
+	if p, exists := app.players.Find(user); exists {
+		return p.Rating
+	}
+	return initialRating
+}
+
+// QuickMatch puts player in the matchmaking queue.
+func (app *App) QuickMatch(player string) error {
+	if app.draining.Load() {
+		return errDraining
+	}
+	if _, exists := app.RoomOf(player); exists {
+		return fmt.Errorf("player %s is already in a room", player)
+	}
+	return app.matchmaker.Enqueue(player, app.Rating(player))
+}
+
+// runMatchmaker pairs queued players every matchmakingInterval.
+func (app *App) runMatchmaker() {
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if app.draining.Load() {
+			continue
+		}
+		for _, pair := range app.matchmaker.Pair(now) {
+			app.startQuickMatch(pair)
+		}
+	}
+}
+
+// startQuickMatch creates a room for a pair found by the matchmaker, players
+// who can not be placed are queued again.
+func (app *App) startQuickMatch(pair [2]string) {
+	room, err := app.CreateRoom(RoomOptions{
+		Mode:       RoomModeQuickMatch,
+		Difficulty: DifficultyNormal,
+		Operators:  DifficultyNormal.Operators,
+		Capacity:   len(pair),
+	})
+	if err != nil {
+		log.Error("failed to create quick match room", "error", err)
+		for _, p := range pair {
+			app.requeue(p)
+		}
+		return
+	}
+
+	joined := make([]string, 0, len(pair))
+	for _, p := range pair {
+		if _, err := app.JoinRoom(p, room); err != nil {
+			log.Warn("failed to join quick match", "player", p, "error", err)
+			continue
+		}
+		joined = append(joined, p)
+	}
+
+	// a match needs both players, otherwise start over
+	if len(joined) != len(pair) {
+		for _, p := range joined {
+			app.LeaveRoom(p)
+			app.requeue(p)
+		}
+		// the last player leaving removes the room, an empty one stays
+		if len(joined) == 0 {
+			app.roomRepo.Remove(room.id)
+		}
+		return
+	}
+
+	log.Infof("quick match in room %d: %s vs %s", room.id, pair[0], pair[1])
+	for _, p := range pair {
+		if prog, exists := app.Session(p); exists {
+			go prog.Send(MatchFound{room: room})
+		}
+	}
+}
+
+func (app *App) requeue(player string) {
+	if _, exists := app.Session(player); !exists {
+		return
+	}
+	if err := app.QuickMatch(player); err != nil {
+		log.Warn("failed to requeue player", "player", player, "error", err)
+	}
+}
+
+// DisplayName returns the nickname of user, or a short fingerprint if it has
+// no profile.
+func (app *App) DisplayName(user string) string {
+	if isBot(user) {
+		return botName(user)
+	}
+	if p, exists := app.players.Find(user); exists {
+		return p.Name
+	}
+	return shortName(user)
 }
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// newTestApp returns an App without SSH server, storing its data in a
+// temporary directory.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	app, err := newApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func newTestRoom(t *testing.T, app *App) *Room {
+	t.Helper()
+
+	r, err := app.CreateRoom(RoomOptions{
+		Mode:       RoomModeCustom,
+		Difficulty: DifficultyNormal,
+		Operators:  DifficultyNormal.Operators,
+		Capacity:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// recorder is a model passing every message it gets to msgs.
+type recorder struct {
+	msgs chan tea.Msg
+}
+
+func (r recorder) Init() tea.Cmd {
+	return nil
+}
+
+func (r recorder) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	r.msgs <- msg
+	return r, nil
+}
+
+func (r recorder) View() string {
+	return ""
+}
+
+// newTestSession returns a running session of user recording its messages.
+func newTestSession(t *testing.T, user string) (*Session, chan tea.Msg) {
+	t.Helper()
+
+	msgs := make(chan tea.Msg, 16)
+	prog := tea.NewProgram(
+		recorder{msgs: msgs},
+		tea.WithInput(nil),
+		tea.WithOutput(io.Discard),
+		tea.WithoutRenderer(),
+		tea.WithoutSignals(),
+	)
+	go prog.Run()
+	t.Cleanup(prog.Kill)
+
+	return &Session{user: user, prog: prog}, msgs
+}
+
+func TestRemoveSessionReportsLast(t *testing.T) {
+	app := newTestApp(t)
+	first, second := &Session{user: "a"}, &Session{user: "a"}
+	app.AddSession(first)
+	app.AddSession(second)
+
+	if n := app.SessionCount(); n != 2 {
+		t.Fatalf("got %d sessions, want 2", n)
+	}
+	if app.RemoveSession(first) {
+		t.Fatal("first session reported as last")
+	}
+	if sessions, _ := app.Session("a"); len(sessions) != 1 || sessions[0] != second {
+		t.Fatalf("got sessions %v, want only the second", sessions)
+	}
+	if !app.RemoveSession(second) {
+		t.Fatal("second session not reported as last")
+	}
+	if _, exists := app.Session("a"); exists {
+		t.Fatal("user still has sessions")
+	}
+}
+
+func TestAttachedSessionKeepsSlot(t *testing.T) {
+	app := newTestApp(t)
+	r := newTestRoom(t, app)
+	first, _ := newTestSession(t, "a")
+	second, _ := newTestSession(t, "a")
+	app.AddSession(first)
+	app.AddSession(second)
+	if _, err := app.JoinRoom("a", r); err != nil {
+		t.Fatal(err)
+	}
+
+	app.Disconnect(first)
+	if got, exists := app.RoomOf("a"); !exists || got != r {
+		t.Fatal("player left the room while a session remains")
+	}
+	if app.tableRepo.FindByPlayer("a") == nil {
+		t.Fatal("table removed while a session remains")
+	}
+
+	app.Disconnect(second)
+	if _, exists := app.RoomOf("a"); exists {
+		t.Fatal("player still in the room after its last session")
+	}
+}
+
+func TestTakeOverQuitsOtherSessions(t *testing.T) {
+	app := newTestApp(t)
+	old, oldMsgs := newTestSession(t, "a")
+	taker, takerMsgs := newTestSession(t, "a")
+	app.AddSession(old)
+	app.AddSession(taker)
+
+	app.TakeOver(taker)
+
+	waitForTakeOver(t, oldMsgs)
+	assertKept(t, takerMsgs)
+}
+
+func TestTakeOverKeepsRoom(t *testing.T) {
+	app := newTestApp(t)
+	r := newTestRoom(t, app)
+	old, _ := newTestSession(t, "a")
+	app.AddSession(old)
+	if _, err := app.JoinRoom("a", r); err != nil {
+		t.Fatal(err)
+	}
+
+	taker, _ := newTestSession(t, "a")
+	app.AddSession(taker)
+	app.TakeOver(taker)
+	// the old session closes once it quits
+	app.Disconnect(old)
+
+	if got, exists := app.RoomOf("a"); !exists || got != r {
+		t.Fatal("player lost its room on take over")
+	}
+	if _, resumed := app.landing("a", "").(*GameModel); !resumed {
+		t.Fatal("new session is not routed back to the room")
+	}
+}
+
+func TestAbortedMatchKeepsSeed(t *testing.T) {
+	app := newTestApp(t)
+	r := newTestRoom(t, app)
+	for _, p := range []string{"a", "b"} {
+		if _, err := app.JoinRoom(p, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.mu.Lock()
+	r.beginCountdown()
+	r.startedAt = time.Now()
+	r.setStatus(RoomPlaying)
+	seed := r.seed
+	r.mu.Unlock()
+
+	app.abortMatch(r)
+
+	records := app.matches.Since(time.Time{})
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	if records[0].Seed != seed {
+		t.Errorf("recorded seed %d, want %d", records[0].Seed, seed)
+	}
+	if !records[0].Aborted {
+		t.Error("match not recorded as aborted")
+	}
+}
+
+func TestDrainStopsOnSignal(t *testing.T) {
+	app := newTestApp(t)
+	app.cfg.DrainTimeout = Duration{time.Hour}
+	r := newTestRoom(t, app)
+	for _, p := range []string{"a", "b"} {
+		if _, err := app.JoinRoom(p, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.mu.Lock()
+	r.beginCountdown()
+	r.startedAt = time.Now()
+	r.setStatus(RoomPlaying)
+	r.mu.Unlock()
+
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	drained := make(chan struct{})
+	go func() {
+		app.Drain(stop)
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain kept waiting after a second signal")
+	}
+	if !app.draining.Load() {
+		t.Error("app not draining")
+	}
+	records := app.matches.Since(time.Time{})
+	if len(records) != 1 || !records[0].Aborted {
+		t.Errorf("got records %+v, want one aborted match", records)
+	}
+}
+
+// openTestSession opens a session of user through the duplicate session
+// policy and runs it, the final model is sent to the returned channel.
+func openTestSession(t *testing.T, app *App, user, joinCode string) (*Session, chan tea.Model) {
+	t.Helper()
+
+	session := app.openSession(user, joinCode, make(chan struct{}),
+		tea.WithInput(nil),
+		tea.WithOutput(io.Discard),
+		tea.WithoutRenderer(),
+		tea.WithoutSignals(),
+	)
+	final := make(chan tea.Model, 1)
+	go func() {
+		m, _ := session.prog.Run()
+		final <- m
+	}()
+	t.Cleanup(session.prog.Kill)
+
+	return session, final
+}
+
+// quitPage quits session and returns the page it was on.
+func quitPage(t *testing.T, session *Session, final chan tea.Model) tea.Model {
+	t.Helper()
+
+	session.prog.Quit()
+	select {
+	case m := <-final:
+		return m.(AppModel).router.(*AppRouter).model
+	case <-time.After(time.Second):
+		t.Fatal("session did not quit")
+		return nil
+	}
+}
+
+// waitForRoom waits until user is in r.
+func waitForRoom(t *testing.T, app *App, user string, r *Room) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		if got, exists := app.RoomOf(user); exists && got == r {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("%s did not join room %d", user, r.id)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// newDuplicateTestApp returns an App with policy where player a has a session.
+func newDuplicateTestApp(t *testing.T, policy SessionPolicy) (*App, chan tea.Msg) {
+	t.Helper()
+
+	app := newTestApp(t)
+	app.cfg.DuplicateSessions = policy
+	if _, err := app.players.Create("a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	old, oldMsgs := newTestSession(t, "a")
+	app.AddSession(old)
+	return app, oldMsgs
+}
+
+// waitForTakeOver waits until the session recording msgs is taken over.
+func waitForTakeOver(t *testing.T, msgs chan tea.Msg) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for taken := false; !taken; {
+		select {
+		case msg := <-msgs:
+			_, taken = msg.(SessionTakenOver)
+		case <-timeout:
+			t.Fatal("session was not taken over")
+		}
+	}
+}
+
+// assertKept fails if the session recording msgs is taken over.
+func assertKept(t *testing.T, msgs chan tea.Msg) {
+	t.Helper()
+
+	for {
+		select {
+		case msg := <-msgs:
+			if _, taken := msg.(SessionTakenOver); taken {
+				t.Fatal("session was taken over")
+			}
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestDuplicateSessionAsks(t *testing.T) {
+	app, oldMsgs := newDuplicateTestApp(t, SessionAsk)
+
+	session, final := openTestSession(t, app, "a", "")
+	if sessions, _ := app.Session("a"); len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	if _, asked := quitPage(t, session, final).(*SessionChoicePage); !asked {
+		t.Error("new session was not asked")
+	}
+	assertKept(t, oldMsgs)
+}
+
+func TestFirstSessionIsNotAsked(t *testing.T) {
+	app := newTestApp(t)
+	if _, err := app.players.Create("a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	session, final := openTestSession(t, app, "a", "")
+	if _, landed := quitPage(t, session, final).(*RoomPage); !landed {
+		t.Error("first session did not land on the room page")
+	}
+}
+
+func TestDuplicateSessionAttaches(t *testing.T) {
+	app, oldMsgs := newDuplicateTestApp(t, SessionAttach)
+	r := newTestRoom(t, app)
+
+	session, final := openTestSession(t, app, "a", r.code)
+	waitForRoom(t, app, "a", r)
+	if sessions, _ := app.Session("a"); len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	if _, asked := quitPage(t, session, final).(*SessionChoicePage); asked {
+		t.Error("attaching session was asked")
+	}
+	assertKept(t, oldMsgs)
+}
+
+func TestDuplicateSessionTakesOver(t *testing.T) {
+	app, oldMsgs := newDuplicateTestApp(t, SessionTakeover)
+	r := newTestRoom(t, app)
+
+	session, final := openTestSession(t, app, "a", r.code)
+	waitForRoom(t, app, "a", r)
+
+	waitForTakeOver(t, oldMsgs)
+	if _, asked := quitPage(t, session, final).(*SessionChoicePage); asked {
+		t.Error("taking over session was asked")
+	}
+}
+
+func TestSessionChoice(t *testing.T) {
+	tests := map[string]struct {
+		key   rune
+		taken bool
+	}{
+		"attach":    {key: 'a'},
+		"take over": {key: 't', taken: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			app, oldMsgs := newDuplicateTestApp(t, SessionAsk)
+			r := newTestRoom(t, app)
+			session, _ := newTestSession(t, "a")
+			app.AddSession(session)
+
+			page := NewSessionChoicePage(session, r.code)
+			page.app, page.user = app, "a"
+			_, cmd := page.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{tt.key}})
+			if cmd == nil {
+				t.Fatal("choice did not route anywhere")
+			}
+			route, ok := cmd().(GotoRoute)
+			if !ok {
+				t.Fatal("choice did not route anywhere")
+			}
+			if _, landed := route.route.(StaticRoute).Model.(*RoomPage); !landed {
+				t.Errorf("got route %T, want the room page", route.route.(StaticRoute).Model)
+			}
+			waitForRoom(t, app, "a", r)
+
+			if tt.taken {
+				waitForTakeOver(t, oldMsgs)
+			} else {
+				assertKept(t, oldMsgs)
+			}
+		})
+	}
+}
+
+func TestSessionChoiceJoinsAfterChoosing(t *testing.T) {
+	app, _ := newDuplicateTestApp(t, SessionAsk)
+	r := newTestRoom(t, app)
+
+	session, _ := openTestSession(t, app, "a", r.code)
+	if _, exists := app.RoomOf("a"); exists {
+		t.Fatal("joined before choosing")
+	}
+	session.prog.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'a'}})
+	waitForRoom(t, app, "a", r)
+}
+
+func TestSessionChoiceQuits(t *testing.T) {
+	app, oldMsgs := newDuplicateTestApp(t, SessionAsk)
+
+	session, final := openTestSession(t, app, "a", "")
+	session.prog.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	select {
+	case <-final:
+	case <-time.After(time.Second):
+		t.Fatal("session did not quit")
+	}
+	assertKept(t, oldMsgs)
+}
+
+func TestQuickMatchRemovesEmptyRoom(t *testing.T) {
+	app := newTestApp(t)
+	// both players are busy, so neither can join the quick match
+	for _, p := range []string{"a", "b"} {
+		if _, err := app.JoinRoom(p, newTestRoom(t, app)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app.startQuickMatch([2]string{"a", "b"})
+	if n := len(app.roomRepo.List()); n != 2 {
+		t.Errorf("got %d rooms, want the 2 rooms of the players", n)
+	}
+}
+
+func TestOnboardingJoinsByCode(t *testing.T) {
+	app := newTestApp(t)
+	r := newTestRoom(t, app)
+
+	page, ok := app.landing("n", r.code).(*OnboardingPage)
+	if !ok {
+		t.Fatal("new player did not land on onboarding")
+	}
+	if _, exists := app.RoomOf("n"); exists {
+		t.Fatal("joined before picking a nickname")
+	}
+	page.app, page.user = app, "n"
+	page.input.SetValue("newbie")
+	if _, cmd := page.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd == nil {
+		t.Fatal("onboarding did not route anywhere")
+	}
+
+	if got, exists := app.RoomOf("n"); !exists || got != r {
+		t.Error("new player did not join the room of the invite code")
+	}
+}
+
+// newReloadTestApp returns an App loaded from the config file it returns the
+// path of.
+func newReloadTestApp(t *testing.T, content string) (*App, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig([]string{"-config", path, "-data-dir", dir}, mapEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := newApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, path
+}
+
+func TestReload(t *testing.T) {
+	app, path := newReloadTestApp(t, "motd = \"old\"\nport = 2000\n")
+
+	if err := os.WriteFile(path, []byte("motd = \"new\"\nport = 3000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := app.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != (ConfigChange{Key: "motd", Old: "old", New: "new"}) {
+		t.Errorf("got changes %v, want only motd", changes)
+	}
+	cfg := app.Config()
+	if cfg.MOTD != "new" {
+		t.Errorf("got motd %q, want the reloaded one", cfg.MOTD)
+	}
+	if cfg.Port != 2000 {
+		t.Errorf("got port %d, want it kept until a restart", cfg.Port)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	app, path := newReloadTestApp(t, "motd = \"old\"\n")
+
+	if err := os.WriteFile(path, []byte("motd = \"new\"\n[board]\ncapacity = 9\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Reload(); err == nil {
+		t.Fatal("reloaded an invalid config")
+	}
+	if cfg := app.Config(); cfg.MOTD != "old" || cfg.Board.Capacity != DefaultConfig().Board.Capacity {
+		t.Error("invalid reload changed the config")
+	}
+}
+
+func TestReloadKeepsDurationOfRooms(t *testing.T) {
+	app, path := newReloadTestApp(t, "game_duration = \"30s\"\n")
+	old := newTestRoom(t, app)
+
+	if err := os.WriteFile(path, []byte("game_duration = \"90s\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if old.duration != 30*time.Second {
+		t.Errorf("existing room got duration %s, want 30s", old.duration)
+	}
+	if r := newTestRoom(t, app); r.duration != 90*time.Second {
+		t.Errorf("new room got duration %s, want 90s", r.duration)
+	}
+}
+
+func TestDrainAdmitsAwayPlayers(t *testing.T) {
+	app := newTestApp(t)
+	r := newTestRoom(t, app)
+	sessions := make(map[string]*Session)
+	for _, p := range []string{"a", "b"} {
+		sessions[p], _ = newTestSession(t, p)
+		app.AddSession(sessions[p])
+		if _, err := app.JoinRoom(p, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.mu.Lock()
+	r.beginCountdown()
+	r.startedAt = time.Now()
+	r.setStatus(RoomPlaying)
+	r.mu.Unlock()
+
+	app.Disconnect(sessions["a"])
+	app.draining.Store(true)
+
+	if err := app.admit("a"); err != nil {
+		t.Errorf("dropped player refused during the drain: %v", err)
+	}
+	if err := app.admit("c"); err == nil {
+		t.Error("new player admitted during the drain")
+	}
+	app.Reconnect("a")
+	if err := app.admit("a"); err == nil {
+		t.Error("player admitted again after reconnecting during the drain")
+	}
+}
+
+func TestKickNotifiesEverySession(t *testing.T) {
+	for _, views := range []int{1, 2} {
+		t.Run(fmt.Sprintf("%d sessions", views), func(t *testing.T) {
+			app := newTestApp(t)
+			r := newTestRoom(t, app)
+			for _, p := range []string{"h", "a"} {
+				if _, err := app.JoinRoom(p, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			inboxes := make([]chan tea.Msg, 0, views)
+			for i := 0; i < views; i++ {
+				session, msgs := newTestSession(t, "a")
+				app.AddSession(session)
+				inboxes = append(inboxes, msgs)
+			}
+
+			if err := app.Kick("h", "a"); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, msgs := range inboxes {
+				kicked := false
+				timeout := time.After(200 * time.Millisecond)
+				for done := false; !done; {
+					select {
+					case msg := <-msgs:
+						switch msg := msg.(type) {
+						case Kicked:
+							kicked = true
+						case Leave:
+							if msg.user == "a" {
+								t.Errorf("session %d was told it left", i)
+							}
+						}
+					case <-timeout:
+						done = true
+					}
+				}
+				if !kicked {
+					t.Errorf("session %d was not told it was kicked", i)
+				}
+			}
+			if _, exists := app.RoomOf("a"); exists {
+				t.Error("kicked player is still in the room")
+			}
+		})
+	}
+}
@@ -4,53 +4,119 @@ import (
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 )
 
+type Operator string
+
 const (
-	OperatorPlug = "+"
+	OperatorPlug   Operator = "+"
+	OperatorMinus  Operator = "-"
+	OperatorTimes  Operator = "×"
+	OperatorDivide Operator = "÷"
+)
+
+// operands returns non-negative integers lhs and rhs so that `lhs op rhs == val`.
+func (op Operator) operands(rng *rand.Rand, val int) (int, int) {
+	switch op {
+	case OperatorMinus:
+		rhs := rng.Intn(val + 1)
+		return val + rhs, rhs
+	case OperatorTimes:
+		if val == 0 {
+			return 0, rng.Intn(10)
+		}
+		divisors := make([]int, 0)
+		for d := 1; d <= val; d++ {
+			if val%d == 0 {
+				divisors = append(divisors, d)
+			}
+		}
+		lhs := divisors[rng.Intn(len(divisors))]
+		return lhs, val / lhs
+	case OperatorDivide:
+		rhs := 1 + rng.Intn(9)
+		return val * rhs, rhs
+	default:
+		if val == 0 {
+			return 0, 0
+		}
+		lhs := rng.Intn(val)
+		return lhs, val - lhs
+	}
+}
+
+type OperatorSet []Operator
+
+var (
+	OperatorSetPlus      = OperatorSet{OperatorPlug}
+	OperatorSetPlusMinus = OperatorSet{OperatorPlug, OperatorMinus}
+	OperatorSetTimes     = OperatorSet{OperatorTimes}
+	OperatorSetDivide    = OperatorSet{OperatorDivide}
+	OperatorSetMixed     = OperatorSet{OperatorPlug, OperatorMinus, OperatorTimes, OperatorDivide}
+
+	operatorSets = []OperatorSet{
+		OperatorSetPlus,
+		OperatorSetPlusMinus,
+		OperatorSetTimes,
+		OperatorSetDivide,
+		OperatorSetMixed,
+	}
 )
 
+func (s OperatorSet) Pick(rng *rand.Rand) Operator {
+	if len(s) == 0 {
+		return OperatorPlug
+	}
+	return s[rng.Intn(len(s))]
+}
+
+func (s OperatorSet) String() string {
+	ops := make([]string, 0, len(s))
+	for _, op := range s {
+		ops = append(ops, string(op))
+	}
+	return strings.Join(ops, " ")
+}
+
 type Formula struct {
 	lhs int
 	rhs int
-	op  string
+	op  Operator
 
 	val int
 }
 
-func NewFormula(val int) *Formula {
-	lhs := rand.Intn(val)
-	rhs := val - lhs
-
-	return &Formula{
-		lhs: lhs,
-		rhs: rhs,
-		op:  OperatorPlug,
-		val: val,
-	}
+func NewFormula(rng *rand.Rand, val int, op Operator) *Formula {
+	f := &Formula{}
+	f.UpdateValue(rng, val, op)
+	return f
 }
 
-func (f *Formula) View() string {
-	styleOperand := lipgloss.NewStyle().Width(2)
+// View renders the formula with operands padded to width digits.
+func (f *Formula) View(width int) string {
+	styleOperand := lipgloss.NewStyle().Width(width)
 	return lipgloss.JoinHorizontal(
 		lipgloss.Center,
 		styleOperand.Align(lipgloss.Right).Render(strconv.Itoa(f.lhs)),
 		" ",
-		f.op,
+		string(f.op),
 		" ",
 		styleOperand.Align(lipgloss.Left).Render(strconv.Itoa(f.rhs)),
 	)
 }
 
-func (f *Formula) UpdateValue(val int) {
-	lhs := rand.Intn(val)
-	rhs := val - lhs
+func (f *Formula) UpdateValue(rng *rand.Rand, val int, op Operator) {
+	lhs, rhs := op.operands(rng, val)
 
 	f.lhs = lhs
 	f.rhs = rhs
+	f.op = op
 	f.val = val
 }
 
@@ -62,33 +128,49 @@ type ArithmeticBlock struct {
 	formula    *Formula
 	isSelected bool
 	isHovered  bool
+	// seq of the last BlockFlags applied to a mirrored block
+	seq uint64
 }
 
-func NewArithmeticBlock(val int) ArithmeticBlock {
+func NewArithmeticBlock(rng *rand.Rand, val int, op Operator) ArithmeticBlock {
 	return ArithmeticBlock{
-		formula:    NewFormula(val),
+		formula:    NewFormula(rng, val, op),
 		isSelected: false,
 		isHovered:  false,
 	}
 }
 
-func (b *ArithmeticBlock) View() string {
-	formula := b.formula.View()
-
+// style returns the block style, remote blocks mirror an opponent's table
+// and are drawn in the opponent colors.
+func (b *ArithmeticBlock) style(remote bool) lipgloss.Style {
 	baseStyle := lipgloss.NewStyle()
 
+	selected, hovered := theme().blockSelected, theme().blockHovered
+	if remote {
+		selected, hovered = theme().opponentSelected, theme().opponentHovered
+	}
+
 	if b.isSelected {
-		baseStyle = baseStyle.Inherit(styleBlockSelected)
+		baseStyle = baseStyle.Inherit(selected)
 	}
 
 	if b.isHovered {
-		baseStyle = baseStyle.Inherit(styleBlockHovered)
+		baseStyle = baseStyle.Inherit(hovered)
 	} else {
-		baseStyle = baseStyle.Inherit(styleBlockNormal)
+		baseStyle = baseStyle.Inherit(theme().blockNormal)
 	}
 
-	style := lipgloss.NewStyle().Padding(1).Border(lipgloss.NormalBorder()).Align(lipgloss.Center, lipgloss.Center).Inherit(baseStyle)
-	return style.Render(formula)
+	return baseStyle
+}
+
+func (b *ArithmeticBlock) View(remote bool, width int) string {
+	style := lipgloss.NewStyle().Padding(1).Border(lipgloss.NormalBorder()).Align(lipgloss.Center, lipgloss.Center).Inherit(b.style(remote))
+	return style.Render(b.formula.View(width))
+}
+
+// ViewMini renders the block without borders and padding.
+func (b *ArithmeticBlock) ViewMini(remote bool, width int) string {
+	return b.style(remote).Render(b.formula.View(width))
 }
 
 func (b *ArithmeticBlock) Toggle() *ArithmeticBlock {
@@ -100,14 +182,34 @@ func (b *ArithmeticBlock) Value() int {
 	return b.formula.Value()
 }
 
-func (b *ArithmeticBlock) UpdateValue(val int) {
-	b.formula.UpdateValue(val)
+func (b *ArithmeticBlock) UpdateValue(rng *rand.Rand, val int, op Operator) {
+	b.formula.UpdateValue(rng, val, op)
 	b.isSelected = false
 }
 
 type ArithmeticTable struct {
-	table      [][]ArithmeticBlock
-	score      int
+	difficulty Difficulty
+	operators  OperatorSet
+	// digits of the widest operand, shared by every block
+	operandWidth int
+
+	// mu guards the table state, it is read by every player in the room
+	mu    sync.Mutex
+	table [][]ArithmeticBlock
+	score int
+	// scorer scores moves on our own table, mirrors only know the total
+	scorer Scorer
+	// remote tables mirror an opponent's table from BlockFlags
+	remote bool
+	// seq numbers the published changes, mirrors drop those older than what
+	// they show; scoreSeq is the seq of the mirrored score
+	seq      uint64
+	scoreSeq uint64
+	// values drives the numbers on the table and is seeded per room, so every
+	// player in a room gets the same sequence; shapes only picks operators
+	// and operands
+	values     *rand.Rand
+	shapes     *rand.Rand
 	hoveredRow int
 	hoveredCol int
 
@@ -118,9 +220,16 @@ type ArithmeticTable struct {
 	updateBlockFlagsCh chan BlockFlags
 }
 
-func NewArithmeticTable(table [][]ArithmeticBlock) *ArithmeticTable {
+func NewArithmeticTable(seed int64, difficulty Difficulty, operators OperatorSet) *ArithmeticTable {
+	values := rand.New(rand.NewSource(seed))
+	shapes := rand.New(rand.NewSource(seed + 1))
 	t := ArithmeticTable{
-		table:              table,
+		table:              genTable(values, shapes, difficulty, operators),
+		difficulty:         difficulty,
+		operators:          operators,
+		operandWidth:       difficulty.operandWidth(operators),
+		values:             values,
+		shapes:             shapes,
 		score:              0,
 		hoveredRow:         0,
 		hoveredCol:         0,
@@ -130,34 +239,165 @@ func NewArithmeticTable(table [][]ArithmeticBlock) *ArithmeticTable {
 	return &t
 }
 
+func (t *ArithmeticTable) Score() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.score
+}
+
 func (t *ArithmeticTable) Render() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	rows := make([]string, 0, len(t.table))
 
 	for _, row := range t.table {
 		rowString := make([]string, 0, len(row))
 		for _, b := range row {
-			rowString = append(rowString, b.View())
+			rowString = append(rowString, b.View(t.remote, t.operandWidth))
 		}
 		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Left, rowString...))
 	}
 
 	width := lipgloss.Width(rows[0])
 	scoreLabel := lipgloss.NewStyle().Align(lipgloss.Left).Render("score: ")
-	scoreValue := lipgloss.NewStyle().Align(lipgloss.Right).Width(width - lipgloss.Width(scoreLabel)).Render(strconv.Itoa(t.score))
+	scoreText := strconv.Itoa(t.score)
+	if !t.remote {
+		scoreText = t.comboText(time.Now()) + scoreText
+	}
+	scoreValue := lipgloss.NewStyle().Align(lipgloss.Right).Width(width - lipgloss.Width(scoreLabel)).Render(scoreText)
 	score := lipgloss.JoinHorizontal(lipgloss.Left, scoreLabel, scoreValue)
 	rows = append([]string{score}, rows...)
 
 	return lipgloss.JoinVertical(lipgloss.Bottom, rows...)
 }
 
+// blockFlags returns the flags of the block at row, col numbered as the next
+// change, t.mu must be held.
+func (t *ArithmeticTable) blockFlags(row, col int, block *ArithmeticBlock) BlockFlags {
+	t.seq++
+	flags := updateBlockFlags(row, col, block)
+	flags.seq = t.seq
+	return flags
+}
+
+// publish sends flags to updateBlockFlagsCh, it must be called without
+// holding t.mu.
+func (t *ArithmeticTable) publish(flags []BlockFlags) {
+	for _, f := range flags {
+		t.updateBlockFlagsCh <- f
+	}
+}
+
+// Mirror returns a copy of t to be kept in sync with Apply, so opponents
+// render what they are told instead of reading t.
+func (t *ArithmeticTable) Mirror() *ArithmeticTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	table := make([][]ArithmeticBlock, 0, len(t.table))
+	for _, row := range t.table {
+		r := make([]ArithmeticBlock, 0, len(row))
+		for _, b := range row {
+			formula := *b.formula
+			b.formula = &formula
+			// flags published so far are part of the copy
+			b.seq = t.seq
+			r = append(r, b)
+		}
+		table = append(table, r)
+	}
+
+	return &ArithmeticTable{
+		difficulty:   t.difficulty,
+		operators:    t.operators,
+		operandWidth: t.operandWidth,
+		table:        table,
+		score:        t.score,
+		scoreSeq:     t.seq,
+		remote:       true,
+		hoveredRow:   t.hoveredRow,
+		hoveredCol:   t.hoveredCol,
+	}
+}
+
+// Apply updates a mirrored block from flags, unless the block shows a later
+// change already.
+func (t *ArithmeticTable) Apply(flags BlockFlags) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if flags.row >= len(t.table) || flags.col >= len(t.table[flags.row]) {
+		return
+	}
+
+	b := &t.table[flags.row][flags.col]
+	if flags.seq <= b.seq {
+		return
+	}
+	b.seq = flags.seq
+	b.isHovered = flags.isHovered
+	b.isSelected = flags.isSelected
+	formula := flags.formula
+	b.formula = &formula
+}
+
+// SetScore updates a mirrored score from msg, unless it is older than the
+// score shown.
+func (t *ArithmeticTable) SetScore(msg Score) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if msg.seq < t.scoreSeq {
+		return
+	}
+	t.scoreSeq = msg.seq
+	t.score = msg.total
+}
+
+// scoreUpdate returns the Score message of user announcing delta and the
+// current total.
+func (t *ArithmeticTable) scoreUpdate(user string, delta int) Score {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Score{user: user, delta: delta, total: t.score, seq: t.seq}
+}
+
+// RenderMini renders the table compactly, e.g. for opponents on a small
+// terminal.
+func (t *ArithmeticTable) RenderMini() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rows := make([]string, 0, len(t.table)+1)
+	rows = append(rows, fmt.Sprintf("score: %d", t.score))
+	for _, row := range t.table {
+		rowString := make([]string, 0, len(row))
+		for _, b := range row {
+			rowString = append(rowString, b.ViewMini(t.remote, t.operandWidth))
+		}
+		rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Left, rowString...))
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
 func (t *ArithmeticTable) updateCursor(updater func()) {
+	t.mu.Lock()
+	flags := make([]BlockFlags, 0, 2)
+
 	t.table[t.hoveredRow][t.hoveredCol].isHovered = false
-	t.updateBlockFlagsCh <- updateBlockFlags(t.hoveredRow, t.hoveredCol, &t.table[t.hoveredRow][t.hoveredCol])
+	flags = append(flags, t.blockFlags(t.hoveredRow, t.hoveredCol, &t.table[t.hoveredRow][t.hoveredCol]))
 
 	updater()
 
 	t.table[t.hoveredRow][t.hoveredCol].isHovered = true
-	t.updateBlockFlagsCh <- updateBlockFlags(t.hoveredRow, t.hoveredCol, &t.table[t.hoveredRow][t.hoveredCol])
+	flags = append(flags, t.blockFlags(t.hoveredRow, t.hoveredCol, &t.table[t.hoveredRow][t.hoveredCol]))
+	t.mu.Unlock()
+
+	t.publish(flags)
 }
 
 func (t *ArithmeticTable) CursorDown() {
@@ -184,44 +424,153 @@ func (t *ArithmeticTable) CursorLeft() {
 	})
 }
 
+// comboText describes the streak or lockout of the table, t.mu must be held.
+func (t *ArithmeticTable) comboText(now time.Time) string {
+	switch {
+	case t.scorer.locked(now):
+		return "locked! "
+	case t.scorer.streak > 1:
+		return fmt.Sprintf("streak %d ×%d  ", t.scorer.streak, t.scorer.multiplier())
+	default:
+		return ""
+	}
+}
+
+// Breakdown returns where the score of the table comes from.
+func (t *ArithmeticTable) Breakdown() ScoreBreakdown {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.scorer.breakdown
+}
+
+// Toggle chooses the hovered block and returns the score change, choosing a
+// second block either matches the pair or costs the penalty.
 func (t *ArithmeticTable) Toggle() int {
+	t.mu.Lock()
+	now := time.Now()
+	if t.scorer.locked(now) {
+		t.mu.Unlock()
+		return 0
+	}
+	flags := make([]BlockFlags, 0, 3)
+
 	b := t.table[t.hoveredRow][t.hoveredCol].Toggle()
-	t.updateBlockFlagsCh <- updateBlockFlags(t.hoveredRow, t.hoveredCol, b)
+	flags = append(flags, t.blockFlags(t.hoveredRow, t.hoveredCol, b))
 
 	score := 0
 	if t.selectedBlock == nil {
 		t.selectedBlock = b
 		t.selectedRow = t.hoveredRow
 		t.selectedCol = t.hoveredCol
+	} else if t.selectedBlock == b {
+		// choosing the selected block again only unselects it
+		t.selectedBlock = nil
 	} else {
 		a := t.selectedBlock
 
 		if a.Value() == b.Value() {
-			a.UpdateValue(1 + rand.Intn(13))
-			b.UpdateValue(1 + rand.Intn(13))
-			score = 1
+			t.refill(a, b)
+			score = t.scorer.match(now, t.difficulty.Points)
 		} else {
 			a.Toggle()
 			b.Toggle()
+			score = t.scorer.miss(now)
 
 			log.Debugf("wrong")
 		}
-		t.updateBlockFlagsCh <- updateBlockFlags(t.selectedRow, t.selectedCol, a)
-		t.updateBlockFlagsCh <- updateBlockFlags(t.hoveredRow, t.hoveredCol, b)
+		flags = append(flags, t.blockFlags(t.selectedRow, t.selectedCol, a))
+		flags = append(flags, t.blockFlags(t.hoveredRow, t.hoveredCol, b))
 		t.selectedBlock = nil
 	}
 
+	t.score += score
+	t.mu.Unlock()
+
+	t.publish(flags)
 	return score
 }
 
+// Values returns the value of every block on the table.
+func (t *ArithmeticTable) Values() [][]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	values := make([][]int, 0, len(t.table))
+	for _, row := range t.table {
+		r := make([]int, 0, len(row))
+		for _, b := range row {
+			r = append(r, b.Value())
+		}
+		values = append(values, r)
+	}
+	return values
+}
+
+// Cursor returns the position of the hovered block.
+func (t *ArithmeticTable) Cursor() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.hoveredRow, t.hoveredCol
+}
+
+// Selection returns the position of the selected block, if any.
+func (t *ArithmeticTable) Selection() (int, int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.selectedRow, t.selectedCol, t.selectedBlock != nil
+}
+
+// refill gives matched blocks a and b new values while keeping at least
+// minPairs matchable pairs on the table.
+func (t *ArithmeticTable) refill(a, b *ArithmeticBlock) {
+	va := t.difficulty.value(t.values)
+	vb := t.difficulty.value(t.values)
+
+	// removing a matched pair drops at most one pair, so giving both blocks
+	// the same value always restores the invariant. values is always drawn
+	// twice to keep the sequence in sync with other players in the room
+	a.UpdateValue(t.shapes, va, t.operators.Pick(t.shapes))
+	b.UpdateValue(t.shapes, vb, t.operators.Pick(t.shapes))
+	if t.countPairs() < minPairs {
+		b.UpdateValue(t.shapes, va, t.operators.Pick(t.shapes))
+	}
+}
+
+// CountPairs returns how many disjoint pairs of equal values are on the table.
+func (t *ArithmeticTable) CountPairs() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.countPairs()
+}
+
+func (t *ArithmeticTable) countPairs() int {
+	counts := make(map[int]int)
+	for _, row := range t.table {
+		for _, b := range row {
+			counts[b.Value()]++
+		}
+	}
+
+	pairs := 0
+	for _, c := range counts {
+		pairs += c / 2
+	}
+	return pairs
+}
+
 type ArithmeticTableRepository interface {
 	FindByPlayer(player string) *ArithmeticTable
-	Create(player string) (*ArithmeticTable, error)
+	Create(player string, seed int64, difficulty Difficulty, operators OperatorSet, penalty Penalty) (*ArithmeticTable, error)
 	Update(player string, updater func(*ArithmeticTable)) error
 	RemoveByPlayer(player string) error
 }
 
 type InMemoryArithmeticTableRepository struct {
+	mu     sync.RWMutex
 	tables map[string]*ArithmeticTable
 }
 
@@ -232,22 +581,29 @@ func NewInMemoryArithmeticTableRepository() *InMemoryArithmeticTableRepository {
 }
 
 func (r *InMemoryArithmeticTableRepository) FindByPlayer(player string) *ArithmeticTable {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	return r.tables[player]
 }
 
-func (r *InMemoryArithmeticTableRepository) Create(player string) (*ArithmeticTable, error) {
-	if t := r.FindByPlayer(player); t != nil {
+func (r *InMemoryArithmeticTableRepository) Create(player string, seed int64, difficulty Difficulty, operators OperatorSet, penalty Penalty) (*ArithmeticTable, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t := r.tables[player]; t != nil {
 		return nil, fmt.Errorf("player %s exists", player)
 	}
 
-	t := NewArithmeticTable(genTable())
+	t := NewArithmeticTable(seed, difficulty, operators)
+	t.scorer.penalty = penalty
 	r.tables[player] = t
 	return t, nil
 }
 
 func (r *InMemoryArithmeticTableRepository) Update(player string, updater func(*ArithmeticTable)) error {
 	t := r.FindByPlayer(player)
-	if r == nil {
+	if t == nil {
 		return fmt.Errorf("player %s not exists", player)
 	}
 	updater(t)
@@ -256,8 +612,10 @@ func (r *InMemoryArithmeticTableRepository) Update(player string, updater func(*
 }
 
 func (r *InMemoryArithmeticTableRepository) RemoveByPlayer(player string) error {
-	t := r.FindByPlayer(player)
-	if t == nil {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t := r.tables[player]; t == nil {
 		return fmt.Errorf("player %s not exists", player)
 	}
 
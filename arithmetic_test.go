@@ -0,0 +1,288 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+const (
+	testSeeds   = 200
+	testRefills = 50
+)
+
+// checkFormula fails t unless the operands of f are non-negative and
+// `lhs op rhs == val`.
+func checkFormula(t *testing.T, f *Formula) {
+	t.Helper()
+
+	if f.lhs < 0 || f.rhs < 0 {
+		t.Fatalf("negative operand in %d %s %d", f.lhs, f.op, f.rhs)
+	}
+
+	var got int
+	switch f.op {
+	case OperatorPlug:
+		got = f.lhs + f.rhs
+	case OperatorMinus:
+		got = f.lhs - f.rhs
+	case OperatorTimes:
+		got = f.lhs * f.rhs
+	case OperatorDivide:
+		if f.rhs == 0 || f.lhs%f.rhs != 0 {
+			t.Fatalf("%d ÷ %d is not an integer", f.lhs, f.rhs)
+		}
+		got = f.lhs / f.rhs
+	default:
+		t.Fatalf("unknown operator %q", f.op)
+	}
+	if got != f.val {
+		t.Fatalf("%d %s %d = %d, want %d", f.lhs, f.op, f.rhs, got, f.val)
+	}
+}
+
+// checkTable fails t unless table keeps minPairs pairs and every formula on
+// it adds up.
+func checkTable(t *testing.T, table *ArithmeticTable) {
+	t.Helper()
+
+	if n := table.CountPairs(); n < minPairs {
+		t.Fatalf("got %d pairs, want at least %d", n, minPairs)
+	}
+	for _, row := range table.table {
+		for _, b := range row {
+			checkFormula(t, b.formula)
+		}
+	}
+}
+
+// pairs returns the positions of disjoint pairs of equal values on table, in
+// reading order of their second block.
+func pairs(table *ArithmeticTable) [][2][2]int {
+	found := make([][2][2]int, 0)
+	seen := make(map[int][2]int)
+	for i, row := range table.Values() {
+		for j, v := range row {
+			if a, exists := seen[v]; exists {
+				found = append(found, [2][2]int{a, {i, j}})
+				delete(seen, v)
+			} else {
+				seen[v] = [2]int{i, j}
+			}
+		}
+	}
+	return found
+}
+
+// findPair returns the positions of two different blocks of table with the
+// same value.
+func findPair(t *testing.T, table *ArithmeticTable) ([2]int, [2]int) {
+	t.Helper()
+
+	found := pairs(table)
+	if len(found) == 0 {
+		t.Fatal("no pair on the table")
+	}
+	return found[0][0], found[0][1]
+}
+
+// drain discards the block flags table publishes until the test ends.
+func drain(t *testing.T, table *ArithmeticTable) {
+	go func() {
+		for range table.updateBlockFlagsCh {
+		}
+	}()
+	t.Cleanup(func() { close(table.updateBlockFlagsCh) })
+}
+
+// toggleAt hovers the block at pos and toggles it.
+func toggleAt(table *ArithmeticTable, pos [2]int) int {
+	table.mu.Lock()
+	table.hoveredRow, table.hoveredCol = pos[0], pos[1]
+	table.mu.Unlock()
+	return table.Toggle()
+}
+
+func TestTableKeepsPairs(t *testing.T) {
+	for _, d := range difficulties {
+		for _, ops := range operatorSets {
+			t.Run(d.Name+" "+ops.String(), func(t *testing.T) {
+				for seed := int64(0); seed < testSeeds; seed++ {
+					table := NewArithmeticTable(seed, d, ops)
+					checkTable(t, table)
+
+					for i := 0; i < testRefills; i++ {
+						a, b := findPair(t, table)
+						table.refill(&table.table[a[0]][a[1]], &table.table[b[0]][b[1]])
+						checkTable(t, table)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestToggleKeepsPairs(t *testing.T) {
+	for _, d := range difficulties {
+		for _, ops := range operatorSets {
+			t.Run(d.Name+" "+ops.String(), func(t *testing.T) {
+				for seed := int64(0); seed < testSeeds/10; seed++ {
+					table := NewArithmeticTable(seed, d, ops)
+					drain(t, table)
+
+					for i := 0; i < testRefills; i++ {
+						a, b := findPair(t, table)
+						toggleAt(table, a)
+						if score := toggleAt(table, b); score <= 0 {
+							t.Fatalf("matching a pair scored %d", score)
+						}
+						if _, _, selected := table.Selection(); selected {
+							t.Fatal("a block is still selected after a match")
+						}
+						checkTable(t, table)
+					}
+					if got := table.Breakdown().Matches; got != testRefills {
+						t.Fatalf("got %d matches, want %d", got, testRefills)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGenValuesPairs(t *testing.T) {
+	for _, d := range difficulties {
+		n := d.Rows * d.Cols
+		for seed := int64(0); seed < testSeeds; seed++ {
+			values := genValues(rand.New(rand.NewSource(seed)), d, minPairs)
+			if len(values) != n {
+				t.Fatalf("%s: got %d values, want %d", d, len(values), n)
+			}
+
+			counts := make(map[int]int)
+			pairs := 0
+			for _, v := range values {
+				if v < 1 || v > d.MaxValue {
+					t.Fatalf("%s: value %d out of range [1, %d]", d, v, d.MaxValue)
+				}
+				counts[v]++
+				if counts[v]%2 == 0 {
+					pairs++
+				}
+			}
+			if pairs < minPairs {
+				t.Fatalf("%s seed %d: got %d pairs, want at least %d", d, seed, pairs, minPairs)
+			}
+		}
+	}
+}
+
+func TestOperands(t *testing.T) {
+	maxValue := 0
+	for _, d := range difficulties {
+		if d.MaxValue > maxValue {
+			maxValue = d.MaxValue
+		}
+	}
+
+	for _, op := range OperatorSetMixed {
+		t.Run(string(op), func(t *testing.T) {
+			for seed := int64(0); seed < testSeeds; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				for val := 0; val <= maxValue; val++ {
+					checkFormula(t, NewFormula(rng, val, op))
+				}
+			}
+		})
+	}
+}
+
+func TestSameSeedDrawsSameValues(t *testing.T) {
+	for _, d := range difficulties {
+		t.Run(d.Name, func(t *testing.T) {
+			for seed := int64(0); seed < testSeeds/10; seed++ {
+				first := NewArithmeticTable(seed, d, d.Operators)
+				second := NewArithmeticTable(seed, d, d.Operators)
+				drain(t, first)
+				drain(t, second)
+
+				// the first player matches pairs from the top, the second
+				// from the bottom, so the tables soon differ
+				drawn := func(table *ArithmeticTable, last bool) int {
+					found := pairs(table)
+					if len(found) == 0 {
+						t.Fatal("no pair on the table")
+					}
+					pair := found[0]
+					if last {
+						pair = found[len(found)-1]
+					}
+					toggleAt(table, pair[0])
+					toggleAt(table, pair[1])
+					// the first chosen block always gets the first value drawn
+					return table.Values()[pair[0][0]][pair[0][1]]
+				}
+
+				for i := 0; i < testRefills; i++ {
+					if a, b := drawn(first, false), drawn(second, true); a != b {
+						t.Fatalf("seed %d refill %d: drew %d and %d", seed, i, a, b)
+					}
+				}
+				if a, b := first.values.Int63(), second.values.Int63(); a != b {
+					t.Fatalf("seed %d: value sequences diverged", seed)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorDropsStaleUpdates(t *testing.T) {
+	table := NewArithmeticTable(1, DifficultyNormal, DifficultyNormal.Operators)
+	mirror := table.Mirror()
+	flags := make(chan BlockFlags, 16)
+	go func() {
+		for f := range table.updateBlockFlagsCh {
+			flags <- f
+		}
+		close(flags)
+	}()
+
+	a, b := findPair(t, table)
+	toggleAt(table, a)
+	first := table.scoreUpdate("a", 0)
+	toggleAt(table, b)
+	second := table.scoreUpdate("a", 2)
+	close(table.updateBlockFlagsCh)
+
+	received := make([]BlockFlags, 0)
+	for f := range flags {
+		received = append(received, f)
+	}
+	// deliver everything in reverse
+	for i := len(received) - 1; i >= 0; i-- {
+		mirror.Apply(received[i])
+	}
+	mirror.SetScore(second)
+	mirror.SetScore(first)
+
+	want, got := table.Values(), mirror.Values()
+	for i := range want {
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("mirror shows %d at %d,%d, want %d", got[i][j], i, j, want[i][j])
+			}
+		}
+	}
+	for i := range mirror.table {
+		for j, block := range mirror.table[i] {
+			if block.isSelected {
+				t.Errorf("mirror shows %d,%d selected", i, j)
+			}
+			if block.isHovered != table.table[i][j].isHovered {
+				t.Errorf("mirror hover at %d,%d is %t", i, j, block.isHovered)
+			}
+		}
+	}
+	if mirror.Score() != table.Score() {
+		t.Errorf("mirror score %d, want %d", mirror.Score(), table.Score())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const botPrefix = "bot:"
+
+// BotSkill tunes how fast and how accurately a bot plays.
+type BotSkill struct {
+	Name string
+	// delay between two cursor moves
+	MoveDelay time.Duration
+	// delay before choosing a block
+	ThinkDelay time.Duration
+	// chance of choosing a wrong block
+	ErrorRate float64
+}
+
+var botSkills = []BotSkill{
+	{Name: "easy", MoveDelay: 450 * time.Millisecond, ThinkDelay: 1500 * time.Millisecond, ErrorRate: 0.25},
+	{Name: "medium", MoveDelay: 300 * time.Millisecond, ThinkDelay: 900 * time.Millisecond, ErrorRate: 0.1},
+	{Name: "hard", MoveDelay: 150 * time.Millisecond, ThinkDelay: 400 * time.Millisecond, ErrorRate: 0.03},
+}
+
+var botCount int64
+
+func isBot(user string) bool {
+	return strings.HasPrefix(user, botPrefix)
+}
+
+// anyBot reports whether a bot is among players.
+func anyBot(players []string) bool {
+	for _, p := range players {
+		if isBot(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// botName returns the display name of a bot id.
+func botName(id string) string {
+	parts := strings.Split(strings.TrimPrefix(id, botPrefix), ":")
+	if len(parts) != 2 {
+		return "bot"
+	}
+	return fmt.Sprintf("%s bot #%s", parts[0], parts[1])
+}
+
+// Bot is a server-side player. It drives its table through the same methods
+// as a human and leaves nothing to tell them apart except its name.
+type Bot struct {
+	id    string
+	skill BotSkill
+	app   *App
+	rng   *rand.Rand
+
+	// table currently driven and the channel stopping its forwarder
+	table   *ArithmeticTable
+	forward chan struct{}
+}
+
+func NewBot(app *App, skill BotSkill) *Bot {
+	n := atomic.AddInt64(&botCount, 1)
+	return &Bot{
+		id:    fmt.Sprintf("%s%s:%d", botPrefix, skill.Name, n),
+		skill: skill,
+		app:   app,
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano() + n)),
+	}
+}
+
+// run plays until the bot leaves its room.
+func (b *Bot) run() {
+	defer b.stopForwarding()
+
+	rematch := false
+	for {
+		r, exists := b.app.RoomOf(b.id)
+		if !exists {
+			log.Infof("bot %s stopped", b.id)
+			return
+		}
+
+		snapshot := r.Snapshot()
+		if snapshot.status != RoomFinished {
+			rematch = false
+		}
+
+		switch snapshot.status {
+		case RoomReadyCheck:
+			if !snapshot.ready[b.id] {
+				if err := b.app.Ready(b.id); err != nil {
+					log.Warn("bot failed to ready", "bot", b.id, "error", err)
+				}
+			}
+		case RoomPlaying:
+			if t := b.app.tableRepo.FindByPlayer(b.id); t != nil {
+				b.play(r, t)
+				continue
+			}
+		case RoomFinished:
+			// bots are always up for another match
+			if !rematch {
+				rematch = true
+				if err := b.app.Rematch(b.id); err != nil {
+					log.Warn("bot failed to rematch", "bot", b.id, "error", err)
+				}
+			}
+		}
+
+		time.Sleep(botPollInterval)
+	}
+}
+
+// play makes one move on t: it picks a target block, walks the cursor there
+// and chooses it.
+func (b *Bot) play(r *Room, t *ArithmeticTable) {
+	b.forwardTable(t)
+
+	row, col, ok := b.target(t)
+	if !ok {
+		time.Sleep(botPollInterval)
+		return
+	}
+
+	b.sleep(b.skill.ThinkDelay)
+	for {
+		if r.Snapshot().status != RoomPlaying {
+			return
+		}
+
+		cr, cc := t.Cursor()
+		switch {
+		case cr < row:
+			t.CursorDown()
+		case cr > row:
+			t.CursorUp()
+		case cc < col:
+			t.CursorRight()
+		case cc > col:
+			t.CursorLeft()
+		default:
+			if s := t.Toggle(); s != 0 {
+				b.app.Send(b.id, t.scoreUpdate(b.id, s))
+			}
+			return
+		}
+		b.sleep(b.skill.MoveDelay)
+	}
+}
+
+// target returns the next block to choose, a wrong one now and then.
+func (b *Bot) target(t *ArithmeticTable) (int, int, bool) {
+	values := t.Values()
+	sr, sc, selected := t.Selection()
+
+	blocks := make([][2]int, 0)
+	for i, row := range values {
+		for j := range row {
+			if !selected || i != sr || j != sc {
+				blocks = append(blocks, [2]int{i, j})
+			}
+		}
+	}
+	if len(blocks) == 0 {
+		return 0, 0, false
+	}
+
+	if selected && b.rng.Float64() < b.skill.ErrorRate {
+		blk := blocks[b.rng.Intn(len(blocks))]
+		return blk[0], blk[1], true
+	}
+
+	if selected {
+		for _, x := range blocks {
+			if values[x[0]][x[1]] == values[sr][sc] {
+				return x[0], x[1], true
+			}
+		}
+	} else {
+		for _, x := range blocks {
+			for _, y := range blocks {
+				if x != y && values[x[0]][x[1]] == values[y[0]][y[1]] {
+					return x[0], x[1], true
+				}
+			}
+		}
+	}
+
+	// nothing matches the selection, pick any block to drop it
+	blk := blocks[b.rng.Intn(len(blocks))]
+	return blk[0], blk[1], true
+}
+
+// sleep waits around d, jittered so bots do not move like clockwork.
+func (b *Bot) sleep(d time.Duration) {
+	time.Sleep(time.Duration(float64(d) * (0.75 + b.rng.Float64()*0.5)))
+}
+
+// forwardTable sends updates of t to the room like a human session does,
+// tables are replaced on rematch so the forwarder follows them.
+func (b *Bot) forwardTable(t *ArithmeticTable) {
+	if b.table == t {
+		return
+	}
+	b.stopForwarding()
+
+	b.table = t
+	b.forward = make(chan struct{})
+	go func(done chan struct{}) {
+		for {
+			select {
+			case <-done:
+				return
+			case evt := <-t.updateBlockFlagsCh:
+				evt.user = b.id
+				go b.app.Send(b.id, evt)
+			}
+		}
+	}(b.forward)
+}
+
+func (b *Bot) stopForwarding() {
+	if b.forward != nil {
+		close(b.forward)
+		b.forward = nil
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
+import "time"
+
 type BlockFlags struct {
 	user       string
 	row        int
 	col        int
 	isHovered  bool
 	isSelected bool
+	// the formula is sent as well, so refilled blocks are mirrored too
+	formula Formula
+	// numbers the changes of a table, messages may arrive out of order
+	seq uint64
 }
 
 func updateBlockFlags(row, col int, block *ArithmeticBlock) BlockFlags {
@@ -14,6 +20,7 @@ func updateBlockFlags(row, col int, block *ArithmeticBlock) BlockFlags {
 		col:        col,
 		isHovered:  block.isHovered,
 		isSelected: block.isSelected,
+		formula:    *block.formula,
 	}
 }
 
@@ -25,8 +32,68 @@ type Join struct {
 type Score struct {
 	user  string
 	delta int
+	total int
+	// seq of the table when total was read
+	seq uint64
 }
 
 type GotoRoute struct {
 	route Route
 }
+
+type Leave struct {
+	user string
+}
+
+type RematchRequested struct {
+	user string
+}
+
+type Rematch struct{}
+
+type RoomStatusChanged struct {
+	status RoomStatus
+	// clock of the current match, set once the room starts playing
+	startedAt time.Time
+	deadline  time.Time
+	// seed of the tables of the current match
+	seed int64
+}
+
+type Ready struct {
+	user string
+}
+
+type Countdown struct {
+	n int
+}
+
+type RoomClosed struct{}
+
+// RoomListChanged is sent to sessions on the room list when a listed room
+// changes.
+type RoomListChanged struct {
+	event RoomEvent
+}
+
+// Kicked is sent to a player removed from its room by the host.
+type Kicked struct{}
+
+// MatchFound is sent to queued players once quick match put them in a room.
+type MatchFound struct {
+	room *Room
+}
+
+// ServerDraining tells sessions the server stops at deadline.
+type ServerDraining struct {
+	deadline time.Time
+}
+
+// PlayerAway tells the room a player lost its connection, or is back.
+type PlayerAway struct {
+	user string
+	away bool
+}
+
+// SessionTakenOver tells a session another one of the same key replaced it.
+type SessionTakenOver struct{}
@@ -1,21 +1,563 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/charmbracelet/lipgloss"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	host         = "0.0.0.0"
-	port         = "23234"
-	gameDuration = time.Second * 60
+	// minimum number of matchable pairs kept on every table
+	minPairs        = 2
+	minRoomCapacity = 2
+	maxRoomCapacity = 8
+	countdownFrom   = 3
+	leaderboardSize = 10
+	initialRating   = 1500
+	eloK            = 32
+	// rating gap accepted by quick match, widened while a player waits
+	matchmakingBaseGap      = 100
+	matchmakingGapPerSecond = 10
+	matchmakingInterval     = time.Second
+	// how often idle bots check their room
+	botPollInterval = 200 * time.Millisecond
+	// every scoreStreakStep matches in a row add one to the multiplier
+	scoreStreakStep    = 3
+	scoreMaxMultiplier = 4
+	// matches this close to the previous one earn a speed bonus
+	scoreSpeedWindow = 2 * time.Second
+	// invite codes are made of letters hard to mistake for each other
+	inviteCodeLength   = 4
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	// environment variables overriding settings start with envPrefix
+	envPrefix = "CSTS_"
 )
 
-var (
-	colorWhite         = lipgloss.Color("#ffffff")
-	colorHovered       = lipgloss.Color("#f368e0")
-	styleBlockNormal   = lipgloss.NewStyle().Foreground(colorWhite).BorderForeground(colorWhite)
-	styleBlockHovered  = lipgloss.NewStyle().Foreground(colorHovered).BorderForeground(colorHovered)
-	styleBlockSelected = lipgloss.NewStyle().Background(colorHovered).Foreground(colorWhite)
+// Config is the runtime configuration of the server. Settings are taken from
+// the defaults, a TOML or YAML file, CSTS_* environment variables and flags,
+// each overriding the previous ones.
+type Config struct {
+	Host         string   `toml:"host" yaml:"host"`
+	Port         int      `toml:"port" yaml:"port"`
+	HostKeys     []string `toml:"host_keys" yaml:"host_keys"`
+	DataDir      string   `toml:"data_dir" yaml:"data_dir"`
+	GameDuration Duration `toml:"game_duration" yaml:"game_duration"`
+	// how long a dropped player keeps its slot in a running match, 0
+	// disables reconnecting
+	ReconnectGrace Duration `toml:"reconnect_grace" yaml:"reconnect_grace"`
+	// how long running matches may go on once the server is stopping
+	DrainTimeout Duration     `toml:"drain_timeout" yaml:"drain_timeout"`
+	Board        BoardConfig  `toml:"board" yaml:"board"`
+	Limits       LimitsConfig `toml:"limits" yaml:"limits"`
+	Theme        Theme        `toml:"theme" yaml:"theme"`
+	// MOTD is shown above the room list
+	MOTD       string          `toml:"motd" yaml:"motd"`
+	RateLimits RateLimitConfig `toml:"rate_limits" yaml:"rate_limits"`
+	Access     AccessConfig    `toml:"access" yaml:"access"`
+	// what happens when a key connects while it has a session
+	DuplicateSessions SessionPolicy `toml:"duplicate_sessions" yaml:"duplicate_sessions"`
+	Penalty           Penalty       `toml:"penalty" yaml:"penalty"`
+
+	// source of the config, kept for reloading
+	args      []string
+	lookupEnv func(string) (string, bool)
+}
+
+// BoardConfig are the defaults of new rooms.
+type BoardConfig struct {
+	Difficulty string `toml:"difficulty" yaml:"difficulty"`
+	Capacity   int    `toml:"capacity" yaml:"capacity"`
+}
+
+type LimitsConfig struct {
+	MaxRooms    int `toml:"max_rooms" yaml:"max_rooms"`
+	MaxSessions int `toml:"max_sessions" yaml:"max_sessions"`
+}
+
+// RateLimitConfig are the number of actions allowed per minute, 0 disables
+// the limit.
+type RateLimitConfig struct {
+	// connections of a remote address
+	ConnectionsPerMinute int `toml:"connections_per_minute" yaml:"connections_per_minute"`
+	// rooms hosted by a player
+	RoomsPerMinute int `toml:"rooms_per_minute" yaml:"rooms_per_minute"`
+}
+
+// AccessConfig are lists of key fingerprints like "SHA256:...".
+type AccessConfig struct {
+	// only allowed keys may connect, everyone is allowed if empty
+	Allow []string `toml:"allow" yaml:"allow"`
+	Deny  []string `toml:"deny" yaml:"deny"`
+	// admins may run `ssh ... admin reload`
+	Admins []string `toml:"admins" yaml:"admins"`
+}
+
+// Allowed reports whether the key with fingerprint may connect.
+func (a AccessConfig) Allowed(fingerprint string) bool {
+	if contains(a.Deny, fingerprint) {
+		return false
+	}
+	return len(a.Allow) == 0 || contains(a.Allow, fingerprint)
+}
+
+func (a AccessConfig) IsAdmin(fingerprint string) bool {
+	return contains(a.Admins, fingerprint)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionPolicy decides what a key connecting again while it has a session
+// gets.
+type SessionPolicy string
+
+const (
+	// the new session chooses between taking over and attaching
+	SessionAsk SessionPolicy = "ask"
+	// the new session replaces the others, which quit
+	SessionTakeover SessionPolicy = "takeover"
+	// the new session is one more view of the same player
+	SessionAttach SessionPolicy = "attach"
 )
+
+// Theme is the palette of the UI, colors are hex codes or ANSI numbers.
+type Theme struct {
+	Text     string `toml:"text" yaml:"text"`
+	Hovered  string `toml:"hovered" yaml:"hovered"`
+	Opponent string `toml:"opponent" yaml:"opponent"`
+}
+
+// Duration is a time.Duration written like "90s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Host:           "0.0.0.0",
+		Port:           23234,
+		HostKeys:       []string{".ssh/id_ed25519"},
+		DataDir:        "data",
+		GameDuration:   Duration{60 * time.Second},
+		DrainTimeout:   Duration{90 * time.Second},
+		ReconnectGrace: Duration{30 * time.Second},
+		Board: BoardConfig{
+			Difficulty: DifficultyNormal.Name,
+			Capacity:   2,
+		},
+		Limits: LimitsConfig{
+			MaxRooms:    1000,
+			MaxSessions: 1000,
+		},
+		Theme: Theme{
+			Text:     "#ffffff",
+			Hovered:  "#f368e0",
+			Opponent: "#48dbfb",
+		},
+		RateLimits: RateLimitConfig{
+			ConnectionsPerMinute: 30,
+			RoomsPerMinute:       10,
+		},
+		DuplicateSessions: SessionAsk,
+		Penalty: Penalty{
+			Mode:    PenaltyPoints,
+			Points:  1,
+			Lockout: Duration{1500 * time.Millisecond},
+		},
+	}
+}
+
+func (c Config) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+func (c Config) PlayersPath() string {
+	return filepath.Join(c.DataDir, "players.json")
+}
+
+func (c Config) MatchesPath() string {
+	return filepath.Join(c.DataDir, "matches.jsonl")
+}
+
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[0-9]{1,3})$`)
+
+// Validate reports every invalid setting.
+func (c Config) Validate() error {
+	errs := make([]error, 0)
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range [1, 65535]", c.Port))
+	}
+	if len(c.HostKeys) == 0 {
+		errs = append(errs, fmt.Errorf("at least one host key is required"))
+	}
+	if c.DataDir == "" {
+		errs = append(errs, fmt.Errorf("data dir is required"))
+	}
+	if c.GameDuration.Duration < 10*time.Second || c.GameDuration.Duration > time.Hour {
+		errs = append(errs, fmt.Errorf("game duration %s out of range [10s, 1h]", c.GameDuration))
+	}
+	if c.DrainTimeout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("drain timeout must not be negative"))
+	}
+	if c.ReconnectGrace.Duration < 0 {
+		errs = append(errs, fmt.Errorf("reconnect grace must not be negative"))
+	}
+	if _, exists := difficultyIndex(c.Board.Difficulty); !exists {
+		errs = append(errs, fmt.Errorf("unknown difficulty %q", c.Board.Difficulty))
+	}
+	if c.Board.Capacity < minRoomCapacity || c.Board.Capacity > maxRoomCapacity {
+		errs = append(errs, fmt.Errorf("capacity %d out of range [%d, %d]", c.Board.Capacity, minRoomCapacity, maxRoomCapacity))
+	}
+	if c.Limits.MaxRooms < 1 {
+		errs = append(errs, fmt.Errorf("max rooms must be positive"))
+	}
+	if c.Limits.MaxSessions < 1 {
+		errs = append(errs, fmt.Errorf("max sessions must be positive"))
+	}
+	if c.RateLimits.ConnectionsPerMinute < 0 || c.RateLimits.RoomsPerMinute < 0 {
+		errs = append(errs, fmt.Errorf("rate limits must not be negative"))
+	}
+	switch c.DuplicateSessions {
+	case SessionAsk, SessionTakeover, SessionAttach:
+	default:
+		errs = append(errs, fmt.Errorf("unknown duplicate session policy %q, use ask, takeover or attach", c.DuplicateSessions))
+	}
+	switch c.Penalty.Mode {
+	case PenaltyPoints, PenaltyLockout:
+	default:
+		errs = append(errs, fmt.Errorf("unknown penalty mode %q, use points or lockout", c.Penalty.Mode))
+	}
+	if c.Penalty.Points < 0 {
+		errs = append(errs, fmt.Errorf("penalty points must not be negative"))
+	}
+	if c.Penalty.Lockout.Duration < 0 || c.Penalty.Lockout.Duration > time.Minute {
+		errs = append(errs, fmt.Errorf("penalty lockout %s out of range [0s, 1m]", c.Penalty.Lockout))
+	}
+	colors := []struct{ name, color string }{
+		{"text", c.Theme.Text},
+		{"hovered", c.Theme.Hovered},
+		{"opponent", c.Theme.Opponent},
+	}
+	for _, c := range colors {
+		if !colorPattern.MatchString(c.color) {
+			errs = append(errs, fmt.Errorf("invalid %s color %q", c.name, c.color))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setting is a configuration value settable by a flag and an environment
+// variable, the variable is envPrefix followed by the flag name in upper
+// snake case.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// setList splits a comma separated value, skipping empty entries, so an empty
+// value clears the list.
+func setList(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var list []string
+		for _, entry := range strings.Split(v, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				list = append(list, entry)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"host", "address to listen on", setString(func(c *Config) *string { return &c.Host })},
+	{"port", "port to listen on", setInt(func(c *Config) *int { return &c.Port })},
+	{"host-keys", "comma separated paths of host keys, created if missing", setList(func(c *Config) *[]string { return &c.HostKeys })},
+	{"data-dir", "directory of players and matches", setString(func(c *Config) *string { return &c.DataDir })},
+	{"game-duration", "duration of a match, e.g. 60s", func(c *Config, v string) error {
+		return c.GameDuration.UnmarshalText([]byte(v))
+	}},
+	{"reconnect-grace", "how long a dropped player may reconnect to its match, e.g. 30s", func(c *Config, v string) error {
+		return c.ReconnectGrace.UnmarshalText([]byte(v))
+	}},
+	{"drain-timeout", "how long matches may go on when stopping, e.g. 90s", func(c *Config, v string) error {
+		return c.DrainTimeout.UnmarshalText([]byte(v))
+	}},
+	{"difficulty", "default difficulty of new rooms", setString(func(c *Config) *string { return &c.Board.Difficulty })},
+	{"capacity", "default capacity of new rooms", setInt(func(c *Config) *int { return &c.Board.Capacity })},
+	{"max-rooms", "maximum number of rooms", setInt(func(c *Config) *int { return &c.Limits.MaxRooms })},
+	{"max-sessions", "maximum number of sessions", setInt(func(c *Config) *int { return &c.Limits.MaxSessions })},
+	{"theme-text", "color of text", setString(func(c *Config) *string { return &c.Theme.Text })},
+	{"theme-hovered", "color of hovered blocks", setString(func(c *Config) *string { return &c.Theme.Hovered })},
+	{"theme-opponent", "color of opponents", setString(func(c *Config) *string { return &c.Theme.Opponent })},
+	{"motd", "message of the day", setString(func(c *Config) *string { return &c.MOTD })},
+	{"connections-per-minute", "connections allowed per remote address", setInt(func(c *Config) *int { return &c.RateLimits.ConnectionsPerMinute })},
+	{"rooms-per-minute", "rooms a player may host", setInt(func(c *Config) *int { return &c.RateLimits.RoomsPerMinute })},
+	{"allow", "comma separated key fingerprints allowed to connect", setList(func(c *Config) *[]string { return &c.Access.Allow })},
+	{"deny", "comma separated key fingerprints denied to connect", setList(func(c *Config) *[]string { return &c.Access.Deny })},
+	{"duplicate-sessions", "ask, takeover or attach when a key connects twice", func(c *Config, v string) error {
+		c.DuplicateSessions = SessionPolicy(v)
+		return nil
+	}},
+	{"penalty", "points or lockout for choosing a wrong pair", func(c *Config, v string) error {
+		c.Penalty.Mode = PenaltyMode(v)
+		return nil
+	}},
+	{"penalty-points", "points taken away for a wrong pair", setInt(func(c *Config) *int { return &c.Penalty.Points })},
+	{"penalty-lockout", "how long a wrong pair locks the table, e.g. 1.5s", func(c *Config, v string) error {
+		return c.Penalty.Lockout.UnmarshalText([]byte(v))
+	}},
+	{"admins", "comma separated key fingerprints of admins", setList(func(c *Config) *[]string { return &c.Access.Admins })},
+}
+
+// LoadConfig builds the configuration from args and the environment, the
+// file is given by -config or CSTS_CONFIG.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fs := flag.NewFlagSet("click-the-same-over-ssh", flag.ContinueOnError)
+	path := fs.String("config", "", "path of a TOML or YAML config file")
+	for _, s := range settings {
+		fs.String(s.name, "", fmt.Sprintf("%s (%s)", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+
+	cfg := DefaultConfig()
+	cfg.args = args
+	cfg.lookupEnv = lookupEnv
+	if *path != "" {
+		if err := loadConfigFile(*path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, exists := lookupEnv(s.env()); exists {
+			if err := s.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", s.env(), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && err == nil {
+				if e := s.set(&cfg, f.Value.String()); e != nil {
+					err = fmt.Errorf("invalid -%s: %w", s.name, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Reload loads the config again from the same flags, environment and file.
+func (c Config) Reload() (Config, error) {
+	if c.lookupEnv == nil {
+		return Config{}, fmt.Errorf("config was not loaded from flags")
+	}
+	return LoadConfig(c.args, c.lookupEnv)
+}
+
+// keepRestartOnly copies settings only read on start from old, they are
+// listed in restartOnly.
+func (c *Config) keepRestartOnly(old Config) {
+	c.Host = old.Host
+	c.Port = old.Port
+	c.HostKeys = old.HostKeys
+	c.DataDir = old.DataDir
+	c.Limits.MaxRooms = old.Limits.MaxRooms
+}
+
+var restartOnly = map[string]bool{
+	"host":             true,
+	"port":             true,
+	"host_keys":        true,
+	"data_dir":         true,
+	"limits.max_rooms": true,
+}
+
+// configValue is a setting flattened to its file key.
+type configValue struct {
+	key   string
+	value string
+}
+
+// flattenConfig lists every setting of c in field order.
+func flattenConfig(c Config) []configValue {
+	values := make([]configValue, 0)
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := field.Tag.Get("toml")
+			if !field.IsExported() || tag == "" {
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(Duration{}) {
+				walk(v.Field(i), prefix+tag+".")
+				continue
+			}
+			values = append(values, configValue{prefix + tag, fmt.Sprint(v.Field(i).Interface())})
+		}
+	}
+	walk(reflect.ValueOf(c), "")
+	return values
+}
+
+// ConfigChange is a setting changed by a reload.
+type ConfigChange struct {
+	Key      string
+	Old, New string
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// diffConfig returns settings differing between old and new.
+func diffConfig(old, new Config) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	oldValues := flattenConfig(old)
+	for i, v := range flattenConfig(new) {
+		if v.value != oldValues[i].value {
+			changes = append(changes, ConfigChange{Key: v.key, Old: oldValues[i].value, New: v.value})
+		}
+	}
+	return changes
+}
+
+// loadConfigFile decodes the file at path over cfg, keys missing from the
+// file keep their value. Unknown keys are rejected, so a misplaced setting
+// is not silently ignored.
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if undecoded := md.Undecoded(); err == nil && len(undecoded) != 0 {
+			err = fmt.Errorf("unknown keys %v", undecoded)
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// an empty file has no document
+		if err = dec.Decode(cfg); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("unknown config format %q, use .toml or .yaml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	return nil
+}
+
+// styles are the lipgloss styles built from a Theme.
+type styles struct {
+	blockNormal      lipgloss.Style
+	blockHovered     lipgloss.Style
+	blockSelected    lipgloss.Style
+	opponentHovered  lipgloss.Style
+	opponentSelected lipgloss.Style
+}
+
+var currentStyles atomic.Pointer[styles]
+
+func init() {
+	applyTheme(DefaultConfig().Theme)
+}
+
+// applyTheme rebuilds the styles from t, sessions pick them up on their next
+// render.
+func applyTheme(t Theme) {
+	text := lipgloss.Color(t.Text)
+	hovered := lipgloss.Color(t.Hovered)
+	opponent := lipgloss.Color(t.Opponent)
+
+	currentStyles.Store(&styles{
+		blockNormal:      lipgloss.NewStyle().Foreground(text).BorderForeground(text),
+		blockHovered:     lipgloss.NewStyle().Foreground(hovered).BorderForeground(hovered),
+		blockSelected:    lipgloss.NewStyle().Background(hovered).Foreground(text),
+		opponentHovered:  lipgloss.NewStyle().Foreground(opponent).BorderForeground(opponent),
+		opponentSelected: lipgloss.NewStyle().Background(opponent).Foreground(text),
+	})
+}
+
+func theme() *styles {
+	return currentStyles.Load()
+}
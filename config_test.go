@@ -0,0 +1,265 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapEnv looks variables up in env instead of the environment.
+func mapEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, exists := env[key]
+		return v, exists
+	}
+}
+
+// writeConfig writes a config file named name to a temporary directory.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.toml": `
+port = 2000
+game_duration = "30s"
+motd = "from file"
+
+[board]
+capacity = 3
+`,
+		"config.yaml": `
+port: 2000
+game_duration: 30s
+motd: from file
+board:
+  capacity: 3
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, content)
+			env := map[string]string{
+				"CSTS_CONFIG": path,
+				"CSTS_PORT":   "3000",
+				"CSTS_MOTD":   "from env",
+			}
+
+			cfg, err := LoadConfig([]string{"-port", "4000"}, mapEnv(env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			def := DefaultConfig()
+			if cfg.Host != def.Host {
+				t.Errorf("got host %q, want the default %q", cfg.Host, def.Host)
+			}
+			if cfg.GameDuration.Duration != 30*time.Second {
+				t.Errorf("got game duration %s, want 30s from the file", cfg.GameDuration)
+			}
+			if cfg.Board.Capacity != 3 {
+				t.Errorf("got capacity %d, want 3 from the file", cfg.Board.Capacity)
+			}
+			if cfg.Board.Difficulty != def.Board.Difficulty {
+				t.Errorf("got difficulty %q, want the default %q", cfg.Board.Difficulty, def.Board.Difficulty)
+			}
+			if cfg.MOTD != "from env" {
+				t.Errorf("got motd %q, want it from the environment", cfg.MOTD)
+			}
+			if cfg.Port != 4000 {
+				t.Errorf("got port %d, want 4000 from the flag", cfg.Port)
+			}
+		})
+	}
+}
+
+func TestConfigFlagOverFile(t *testing.T) {
+	path := writeConfig(t, "config.toml", `motd = "from file"`)
+
+	cfg, err := LoadConfig([]string{"-config", path, "-motd", "from flag"}, mapEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MOTD != "from flag" {
+		t.Errorf("got motd %q, want it from the flag", cfg.MOTD)
+	}
+}
+
+func TestConfigRejectsUnknownKeys(t *testing.T) {
+	files := map[string]string{
+		// motd after a table header belongs to the table
+		"misplaced.toml": "[theme]\ntext = \"#ffffff\"\nmotd = \"Welcome!\"\n",
+		"unknown.toml":   "prot = 2000\n",
+		"misplaced.yaml": "theme:\n  text: \"#ffffff\"\n  motd: Welcome!\n",
+		"unknown.yaml":   "prot: 2000\n",
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, content)
+			if _, err := LoadConfig([]string{"-config", path}, mapEnv(nil)); err == nil {
+				t.Error("loaded a config with an unknown key")
+			}
+		})
+	}
+}
+
+func TestConfigEmptyFile(t *testing.T) {
+	for _, name := range []string{"empty.toml", "empty.yaml"} {
+		path := writeConfig(t, name, "")
+		if _, err := LoadConfig([]string{"-config", path}, mapEnv(nil)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestConfigInvalidValues(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+	}{
+		"flag":         {args: []string{"-port", "ssh"}},
+		"env":          {env: map[string]string{"CSTS_GAME_DURATION": "a minute"}},
+		"validated":    {args: []string{"-capacity", "9"}},
+		"format":       {args: []string{"-config", "config.json"}},
+		"missing":      {args: []string{"-config", "missing.toml"}},
+		"unknown flag": {args: []string{"-prot", "2000"}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadConfig(tt.args, mapEnv(tt.env)); err == nil {
+				t.Error("loaded an invalid config")
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	tests := map[string]func(c *Config){
+		"port":               func(c *Config) { c.Port = 70000 },
+		"host keys":          func(c *Config) { c.HostKeys = nil },
+		"data dir":           func(c *Config) { c.DataDir = "" },
+		"game duration":      func(c *Config) { c.GameDuration.Duration = time.Second },
+		"drain timeout":      func(c *Config) { c.DrainTimeout.Duration = -time.Second },
+		"reconnect grace":    func(c *Config) { c.ReconnectGrace.Duration = -time.Second },
+		"difficulty":         func(c *Config) { c.Board.Difficulty = "impossible" },
+		"capacity":           func(c *Config) { c.Board.Capacity = 1 },
+		"max rooms":          func(c *Config) { c.Limits.MaxRooms = 0 },
+		"max sessions":       func(c *Config) { c.Limits.MaxSessions = 0 },
+		"rate limits":        func(c *Config) { c.RateLimits.RoomsPerMinute = -1 },
+		"duplicate sessions": func(c *Config) { c.DuplicateSessions = "kick" },
+		"penalty mode":       func(c *Config) { c.Penalty.Mode = "none" },
+		"penalty points":     func(c *Config) { c.Penalty.Points = -1 },
+		"penalty lockout":    func(c *Config) { c.Penalty.Lockout.Duration = time.Hour },
+		"color":              func(c *Config) { c.Theme.Hovered = "pink" },
+	}
+
+	for name, invalidate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			invalidate(&cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Error("invalid config passed validation")
+			}
+		})
+	}
+}
+
+func TestConfigValidateReportsEveryError(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Board.Difficulty = "impossible"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config passed validation")
+	}
+	for _, want := range []string{"port", "difficulty"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestConfigLists(t *testing.T) {
+	env := map[string]string{
+		"CSTS_ALLOW": "",
+		"CSTS_DENY":  " SHA256:a, ,SHA256:b ,",
+	}
+
+	cfg, err := LoadConfig(nil, mapEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Access.Allow != nil {
+		t.Errorf("got allow list %q, want it empty", cfg.Access.Allow)
+	}
+	if !cfg.Access.Allowed("SHA256:c") {
+		t.Error("an empty allow list locked a key out")
+	}
+	if len(cfg.Access.Deny) != 2 || cfg.Access.Deny[0] != "SHA256:a" || cfg.Access.Deny[1] != "SHA256:b" {
+		t.Errorf("got deny list %q, want [SHA256:a SHA256:b]", cfg.Access.Deny)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := DefaultConfig()
+	new := DefaultConfig()
+	new.Port = 2000
+	new.Board.Capacity = 4
+	new.Penalty.Lockout = Duration{3 * time.Second}
+
+	want := []ConfigChange{
+		{Key: "port", Old: "23234", New: "2000"},
+		{Key: "board.capacity", Old: "2", New: "4"},
+		{Key: "penalty.lockout", Old: "1.5s", New: "3s"},
+	}
+	got := diffConfig(old, new)
+	if len(got) != len(want) {
+		t.Fatalf("got changes %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got change %v, want %v", got[i], want[i])
+		}
+	}
+	if changes := diffConfig(old, old); len(changes) != 0 {
+		t.Errorf("got changes %v of the same config", changes)
+	}
+}
+
+func TestKeepRestartOnly(t *testing.T) {
+	old := DefaultConfig()
+	cfg := DefaultConfig()
+	cfg.Host = "127.0.0.1"
+	cfg.Port = 2000
+	cfg.HostKeys = []string{"key"}
+	cfg.DataDir = "elsewhere"
+	cfg.Limits.MaxRooms = 1
+	cfg.MOTD = "changed"
+
+	cfg.keepRestartOnly(old)
+	for _, c := range diffConfig(old, cfg) {
+		if restartOnly[c.Key] {
+			t.Errorf("restart only setting %s changed", c)
+		}
+	}
+	if cfg.MOTD != "changed" {
+		t.Error("kept a setting that may be reloaded")
+	}
+}
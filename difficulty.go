@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Difficulty sets the shape of the tables of a room.
+type Difficulty struct {
+	Name string
+	Rows int
+	Cols int
+	// values on the table are in [1, MaxValue]
+	MaxValue int
+	// operators used unless the room picks its own
+	Operators OperatorSet
+	// points for every matched pair
+	Points int
+}
+
+var (
+	DifficultyEasy   = Difficulty{Name: "easy", Rows: 3, Cols: 3, MaxValue: 20, Operators: OperatorSetPlus, Points: 1}
+	DifficultyNormal = Difficulty{Name: "normal", Rows: 4, Cols: 3, MaxValue: 13, Operators: OperatorSetPlusMinus, Points: 2}
+	DifficultyHard   = Difficulty{Name: "hard", Rows: 6, Cols: 5, MaxValue: 144, Operators: OperatorSet{OperatorPlug, OperatorMinus, OperatorTimes}, Points: 3}
+
+	difficulties = []Difficulty{DifficultyEasy, DifficultyNormal, DifficultyHard}
+)
+
+func (d Difficulty) String() string {
+	return d.Name
+}
+
+// value draws a value for a block.
+func (d Difficulty) value(rng *rand.Rand) int {
+	return 1 + rng.Intn(d.MaxValue)
+}
+
+// operandWidth returns how many digits the widest operand of a formula can
+// have with operators, so every block of a table has the same width.
+func (d Difficulty) operandWidth(operators OperatorSet) int {
+	largest := d.MaxValue
+	for _, op := range operators {
+		switch op {
+		case OperatorMinus:
+			if 2*d.MaxValue > largest {
+				largest = 2 * d.MaxValue
+			}
+		case OperatorDivide:
+			if 9*d.MaxValue > largest {
+				largest = 9 * d.MaxValue
+			}
+		}
+	}
+
+	width := len(strconv.Itoa(largest))
+	if width < 2 {
+		width = 2
+	}
+	return width
+}
+
+// difficultyIndex returns the index of the preset named name.
+func difficultyIndex(name string) (int, bool) {
+	for i, d := range difficulties {
+		if strings.EqualFold(d.Name, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
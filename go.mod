@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/76creates/stickers v1.3.1-0.20230410064447-c0cf398aec57
+	github.com/BurntSushi/toml v1.3.2
 	github.com/charmbracelet/bubbles v0.18.0
 	github.com/charmbracelet/bubbletea v0.25.0
 	github.com/charmbracelet/lipgloss v0.10.0
@@ -12,6 +13,7 @@ require (
 	github.com/charmbracelet/wish v1.3.2
 	github.com/muesli/termenv v0.15.2
 	golang.org/x/crypto v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
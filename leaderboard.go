@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type LeaderboardPeriod int
+
+const (
+	PeriodAllTime LeaderboardPeriod = iota
+	PeriodWeekly
+	PeriodDaily
+)
+
+var leaderboardPeriods = []LeaderboardPeriod{PeriodAllTime, PeriodWeekly, PeriodDaily}
+
+func (p LeaderboardPeriod) String() string {
+	switch p {
+	case PeriodWeekly:
+		return "weekly"
+	case PeriodDaily:
+		return "daily"
+	default:
+		return "all-time"
+	}
+}
+
+// Since returns the start of the period containing now.
+func (p LeaderboardPeriod) Since(now time.Time) time.Time {
+	switch p {
+	case PeriodWeekly:
+		return now.AddDate(0, 0, -7)
+	case PeriodDaily:
+		return now.AddDate(0, 0, -1)
+	default:
+		return time.Time{}
+	}
+}
+
+type LeaderboardSort int
+
+const (
+	SortByWins LeaderboardSort = iota
+	SortByBestScore
+	SortByRate
+)
+
+var leaderboardSorts = []LeaderboardSort{SortByWins, SortByBestScore, SortByRate}
+
+func (s LeaderboardSort) String() string {
+	switch s {
+	case SortByBestScore:
+		return "best score"
+	case SortByRate:
+		return "matches / min"
+	default:
+		return "wins"
+	}
+}
+
+type LeaderboardEntry struct {
+	Fingerprint string
+	Wins        int
+	Games       int
+	BestScore   int
+	TotalScore  int
+	PlayTime    time.Duration
+	// pairs matched in every game
+	TotalMatches int
+}
+
+// Rate returns how many pairs the player matches per minute of play.
+func (e LeaderboardEntry) Rate() float64 {
+	if e.PlayTime <= 0 {
+		return 0
+	}
+	return float64(e.TotalMatches) / e.PlayTime.Minutes()
+}
+
+func (e LeaderboardEntry) less(other LeaderboardEntry, by LeaderboardSort) bool {
+	switch by {
+	case SortByBestScore:
+		if e.BestScore != other.BestScore {
+			return e.BestScore > other.BestScore
+		}
+	case SortByRate:
+		if e.Rate() != other.Rate() {
+			return e.Rate() > other.Rate()
+		}
+	default:
+		if e.Wins != other.Wins {
+			return e.Wins > other.Wins
+		}
+	}
+	return e.Fingerprint < other.Fingerprint
+}
+
+// BuildLeaderboard aggregates matches per player, sorted by the given order.
+func BuildLeaderboard(matches []MatchRecord, by LeaderboardSort) []LeaderboardEntry {
+	entries := make(map[string]*LeaderboardEntry)
+	for _, m := range matches {
+		if m.Aborted {
+			continue
+		}
+		for _, me := range m.Entries {
+			e, exists := entries[me.Fingerprint]
+			if !exists {
+				e = &LeaderboardEntry{Fingerprint: me.Fingerprint}
+				entries[me.Fingerprint] = e
+			}
+
+			e.Games++
+			e.TotalScore += me.Score
+			e.TotalMatches += me.Matches
+			e.PlayTime += m.Duration
+			if me.Won {
+				e.Wins++
+			}
+			if me.Score > e.BestScore {
+				e.BestScore = me.Score
+			}
+		}
+	}
+
+	board := make([]LeaderboardEntry, 0, len(entries))
+	for _, e := range entries {
+		board = append(board, *e)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		return board[i].less(board[j], by)
+	})
+
+	return board
+}
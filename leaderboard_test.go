@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderboardRateCountsMatches(t *testing.T) {
+	matches := []MatchRecord{
+		{
+			Duration: time.Minute,
+			Entries:  []MatchEntry{{Fingerprint: "a", Score: 50, Matches: 10, Won: true}},
+		},
+		{
+			Duration: time.Minute,
+			Entries:  []MatchEntry{{Fingerprint: "a", Score: 70, Matches: 20}},
+		},
+		{
+			Duration: time.Minute,
+			Entries:  []MatchEntry{{Fingerprint: "a", Score: 99, Matches: 99}},
+			Aborted:  true,
+		},
+	}
+
+	board := BuildLeaderboard(matches, SortByRate)
+	if len(board) != 1 {
+		t.Fatalf("got %d entries, want 1", len(board))
+	}
+	e := board[0]
+	if e.Games != 2 || e.Wins != 1 || e.BestScore != 70 {
+		t.Errorf("got %d games, %d wins, best %d, want 2, 1, 70", e.Games, e.Wins, e.BestScore)
+	}
+	if got := e.Rate(); got != 15 {
+		t.Errorf("got rate %.2f, want 15 matches per minute", got)
+	}
+}
@@ -1,6 +1,22 @@
 package main
 
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/charmbracelet/log"
+)
+
 func main() {
-	app := NewApp()
+	cfg, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Could not load config", "error", err)
+	}
+
+	app := NewApp(cfg)
 	app.Start()
 }
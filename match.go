@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MatchRecord is a finished match as stored for leaderboards.
+type MatchRecord struct {
+	PlayedAt time.Time     `json:"played_at"`
+	Duration time.Duration `json:"duration"`
+	Entries  []MatchEntry  `json:"entries"`
+	// Seed of the tables, the match can be replayed from it
+	Seed int64 `json:"seed"`
+	// Aborted matches were cut short by a shutdown, they do not count for
+	// leaderboards
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+type MatchEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Score       int    `json:"score"`
+	Won         bool   `json:"won"`
+	// Matches is the number of matched pairs, the score also counts bonuses
+	// and penalties
+	Matches int `json:"matches"`
+}
+
+func NewMatchRecord(result MatchResult, playedAt time.Time) MatchRecord {
+	winners := result.Winners()
+	record := MatchRecord{
+		PlayedAt: playedAt,
+		Duration: result.duration,
+		Entries:  make([]MatchEntry, 0, len(result.players)),
+		Seed:     result.seed,
+		Aborted:  result.aborted,
+	}
+	for i, p := range result.players {
+		record.Entries = append(record.Entries, MatchEntry{
+			Fingerprint: p,
+			Score:       result.scores[i],
+			Matches:     result.breakdowns[i].Matches,
+			Won:         len(winners) == 1 && winners[0] == p,
+		})
+	}
+	return record
+}
+
+type MatchRepository interface {
+	Add(record MatchRecord) error
+	// Since returns matches played at or after t.
+	Since(t time.Time) []MatchRecord
+}
+
+// JSONLinesMatchRepository appends every match as one JSON line to a file.
+type JSONLinesMatchRepository struct {
+	path string
+
+	mu      sync.RWMutex
+	matches []MatchRecord
+}
+
+func NewJSONLinesMatchRepository(path string) (*JSONLinesMatchRepository, error) {
+	r := &JSONLinesMatchRepository{
+		path:    path,
+		matches: make([]MatchRecord, 0),
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read matches: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record MatchRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse matches: %w", err)
+		}
+		r.matches = append(r.matches, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read matches: %w", err)
+	}
+
+	return r, nil
+}
+
+func (r *JSONLinesMatchRepository) Add(record MatchRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode match: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	r.matches = append(r.matches, record)
+	return nil
+}
+
+func (r *JSONLinesMatchRepository) Since(t time.Time) []MatchRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// matches are appended in order, so find the first one not before t
+	i := sort.Search(len(r.matches), func(i int) bool {
+		return !r.matches[i].PlayedAt.Before(t)
+	})
+	return append([]MatchRecord(nil), r.matches[i:]...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EloDeltas returns the rating change of every player after a match. Each
+// pair of players counts as a game decided by their scores, and K is split
+// among the opponents so the total stake does not grow with room size.
+func EloDeltas(ratings []float64, scores []int) []float64 {
+	deltas := make([]float64, len(ratings))
+	if len(ratings) < 2 {
+		return deltas
+	}
+
+	k := eloK / float64(len(ratings)-1)
+	for i := range ratings {
+		for j := range ratings {
+			if i == j {
+				continue
+			}
+
+			expected := 1 / (1 + math.Pow(10, (ratings[j]-ratings[i])/400))
+			actual := 0.5
+			if scores[i] > scores[j] {
+				actual = 1
+			} else if scores[i] < scores[j] {
+				actual = 0
+			}
+			deltas[i] += k * (actual - expected)
+		}
+	}
+
+	return deltas
+}
+
+type queueEntry struct {
+	player string
+	rating float64
+	since  time.Time
+}
+
+// allowedGap returns the rating gap the entry accepts, it widens the longer
+// the player waits.
+func (e queueEntry) allowedGap(now time.Time) float64 {
+	return matchmakingBaseGap + matchmakingGapPerSecond*now.Sub(e.since).Seconds()
+}
+
+// Matchmaker pairs waiting players by rating.
+type Matchmaker struct {
+	mu    sync.Mutex
+	queue []queueEntry
+}
+
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker{queue: make([]queueEntry, 0)}
+}
+
+func (mm *Matchmaker) Enqueue(player string, rating float64) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, e := range mm.queue {
+		if e.player == player {
+			return fmt.Errorf("player %s is already queued", player)
+		}
+	}
+
+	mm.queue = append(mm.queue, queueEntry{player: player, rating: rating, since: time.Now()})
+	return nil
+}
+
+func (mm *Matchmaker) Dequeue(player string) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for i, e := range mm.queue {
+		if e.player == player {
+			mm.queue = append(mm.queue[:i], mm.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// Entry returns the queue entry of player.
+func (mm *Matchmaker) Entry(player string) (queueEntry, bool) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, e := range mm.queue {
+		if e.player == player {
+			return e, true
+		}
+	}
+	return queueEntry{}, false
+}
+
+// Pair removes and returns pairs of players close enough in rating. Players
+// who waited longest are served first, each with the closest acceptable
+// opponent.
+func (mm *Matchmaker) Pair(now time.Time) [][2]string {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	sort.SliceStable(mm.queue, func(i, j int) bool {
+		return mm.queue[i].since.Before(mm.queue[j].since)
+	})
+
+	paired := make(map[int]bool)
+	pairs := make([][2]string, 0)
+	for i, a := range mm.queue {
+		if paired[i] {
+			continue
+		}
+
+		best := -1
+		bestGap := math.Inf(1)
+		for j := i + 1; j < len(mm.queue); j++ {
+			b := mm.queue[j]
+			gap := math.Abs(a.rating - b.rating)
+			if paired[j] || gap > math.Max(a.allowedGap(now), b.allowedGap(now)) {
+				continue
+			}
+			if gap < bestGap {
+				best, bestGap = j, gap
+			}
+		}
+
+		if best != -1 {
+			paired[i], paired[best] = true, true
+			pairs = append(pairs, [2]string{a.player, mm.queue[best].player})
+		}
+	}
+
+	rest := make([]queueEntry, 0, len(mm.queue)-2*len(pairs))
+	for i, e := range mm.queue {
+		if !paired[i] {
+			rest = append(rest, e)
+		}
+	}
+	mm.queue = rest
+
+	return pairs
+}
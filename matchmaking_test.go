@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEloDeltas(t *testing.T) {
+	tests := map[string]struct {
+		ratings []float64
+		scores  []int
+		want    []float64
+	}{
+		"alone":           {ratings: []float64{1500}, scores: []int{10}, want: []float64{0}},
+		"even win":        {ratings: []float64{1500, 1500}, scores: []int{10, 5}, want: []float64{eloK / 2, -eloK / 2}},
+		"even draw":       {ratings: []float64{1500, 1500}, scores: []int{7, 7}, want: []float64{0, 0}},
+		"favourite wins":  {ratings: []float64{1900, 1500}, scores: []int{10, 5}, want: []float64{eloK / 11.0, -eloK / 11.0}},
+		"upset":           {ratings: []float64{1500, 1900}, scores: []int{10, 5}, want: []float64{eloK * 10 / 11.0, -eloK * 10 / 11.0}},
+		"draw of unequal": {ratings: []float64{1900, 1500}, scores: []int{5, 5}, want: []float64{-eloK * 9 / 22.0, eloK * 9 / 22.0}},
+		// K is split among the two opponents of each player
+		"three even": {ratings: []float64{1500, 1500, 1500}, scores: []int{3, 2, 1}, want: []float64{eloK / 2, 0, -eloK / 2}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := EloDeltas(tt.ratings, tt.scores)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d deltas, want %d", len(got), len(tt.want))
+			}
+			sum := 0.0
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("player %d: got %.4f, want %.4f", i, got[i], tt.want[i])
+				}
+				sum += got[i]
+			}
+			if math.Abs(sum) > 1e-9 {
+				t.Errorf("deltas add up to %.4f, want 0", sum)
+			}
+		})
+	}
+}
+
+func TestAllowedGapWidens(t *testing.T) {
+	now := time.Now()
+	e := queueEntry{since: now}
+
+	tests := []struct {
+		waited time.Duration
+		want   float64
+	}{
+		{0, matchmakingBaseGap},
+		{time.Second, matchmakingBaseGap + matchmakingGapPerSecond},
+		{30 * time.Second, matchmakingBaseGap + 30*matchmakingGapPerSecond},
+	}
+	for _, tt := range tests {
+		if got := e.allowedGap(now.Add(tt.waited)); got != tt.want {
+			t.Errorf("after %s: got gap %.0f, want %.0f", tt.waited, got, tt.want)
+		}
+	}
+}
+
+func TestMatchmakerPair(t *testing.T) {
+	type entry struct {
+		player string
+		rating float64
+		// how long the player waited
+		waited time.Duration
+	}
+	tests := map[string]struct {
+		queue []entry
+		want  [][2]string
+		rest  []string
+	}{
+		"empty": {},
+		"alone": {
+			queue: []entry{{"a", 1500, 0}},
+			rest:  []string{"a"},
+		},
+		"closest rating": {
+			queue: []entry{{"a", 1500, 3 * time.Second}, {"b", 1580, 2 * time.Second}, {"c", 1520, time.Second}},
+			want:  [][2]string{{"a", "c"}},
+			rest:  []string{"b"},
+		},
+		"longest waiting first": {
+			queue: []entry{{"a", 1500, time.Second}, {"b", 1510, 3 * time.Second}, {"c", 1505, 2 * time.Second}},
+			want:  [][2]string{{"b", "c"}},
+			rest:  []string{"a"},
+		},
+		"gap too wide": {
+			queue: []entry{{"a", 1500, 0}, {"b", 1700, 0}},
+			rest:  []string{"a", "b"},
+		},
+		// either player may have waited long enough for the gap
+		"gap widened": {
+			queue: []entry{{"a", 1500, 11 * time.Second}, {"b", 1700, 0}},
+			want:  [][2]string{{"a", "b"}},
+		},
+		"two pairs": {
+			queue: []entry{{"a", 1500, 4 * time.Second}, {"b", 2000, 3 * time.Second}, {"c", 1550, 2 * time.Second}, {"d", 1990, time.Second}},
+			want:  [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			mm := NewMatchmaker()
+			for _, e := range tt.queue {
+				mm.queue = append(mm.queue, queueEntry{player: e.player, rating: e.rating, since: now.Add(-e.waited)})
+			}
+
+			got := mm.Pair(now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got pairs %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got pairs %v, want %v", got, tt.want)
+				}
+			}
+			if len(mm.queue) != len(tt.rest) {
+				t.Fatalf("got %d players left, want %v", len(mm.queue), tt.rest)
+			}
+			for _, p := range tt.rest {
+				if _, queued := mm.Entry(p); !queued {
+					t.Errorf("%s left the queue", p)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchmakerEnqueueTwice(t *testing.T) {
+	mm := NewMatchmaker()
+	if err := mm.Enqueue("a", 1500); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Enqueue("a", 1500); err == nil {
+		t.Error("queued a player twice")
+	}
+	mm.Dequeue("a")
+	if _, queued := mm.Entry("a"); queued {
+		t.Error("dequeued player is still queued")
+	}
+}
@@ -3,13 +3,13 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/76creates/stickers/flexbox"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/progress"
-	"github.com/charmbracelet/bubbles/timer"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
@@ -21,6 +21,12 @@ type keymap struct {
 	left   key.Binding
 	right  key.Binding
 	choose key.Binding
+	ready  key.Binding
+	leave  key.Binding
+	addBot key.Binding
+	skill  key.Binding
+	kick   key.Binding
+	target key.Binding
 }
 
 type GameModel struct {
@@ -29,13 +35,40 @@ type GameModel struct {
 
 	flexBox *flexbox.FlexBox
 
-	userLeft   string
-	tableLeft  *ArithmeticTable
-	userRight  string
-	tableRight *ArithmeticTable
-
-	timer         timer.Model
+	table     *ArithmeticTable
+	opponents []Opponent
+	// spectators have no table and only watch the opponents
+	spectating bool
+	// set when a reconnected player is put back into its running match
+	resume bool
+	// players whose connection dropped
+	away map[string]bool
+	// closed when the session ends
+	sessionDone <-chan struct{}
+	// key of the personal best in practice rooms, empty in matches
+	practice string
+	best     int
+	hasBest  bool
+	// index of botSkills used for bots added from the lobby
+	botSkill int
+	// invite code of the room, and the opponent the host would kick
+	code       string
+	kickTarget int
+
+	capacity  int
+	status    RoomStatus
+	ready     map[string]bool
+	countdown int
+
+	// the clock is owned by the room, so every player sees the same deadline
+	startedAt     time.Time
+	deadline      time.Time
 	timerProgress progress.Model
+	// seed of the tables, shown with the result so the match can be replayed
+	seed int64
+
+	// closed when the match is over to stop forwarding block updates
+	done chan struct{}
 
 	keymap keymap
 	help   help.Model
@@ -50,28 +83,58 @@ func tickCmd() tea.Cmd {
 }
 
 func (m *GameModel) Init() tea.Cmd {
-	go func() {
-		for {
-			if m.tableLeft == nil || m.tableRight == nil {
-				log.Infof("player %s waiting...", m.user)
-				time.Sleep(time.Second * 1)
-				continue
-			}
+	r, exists := m.app.RoomOf(m.user)
+	if !exists {
+		r, exists = m.app.SpectatedRoom(m.user)
+	}
+	if exists {
+		snapshot := r.Snapshot()
+		m.capacity = r.capacity
+		m.status = snapshot.status
+		m.countdown = snapshot.countdown
+		m.startedAt = snapshot.startedAt
+		m.deadline = snapshot.deadline
+		m.seed = snapshot.seed
+		m.ready = snapshot.ready
+		m.away = snapshot.away
+		m.code = r.code
+		if r.practice() {
+			m.practice = r.practiceKey()
+			m.best, m.hasBest = m.app.PracticeBest(m.user, m.practice)
+		}
+	}
 
-			select {
-			case evt := <-m.tableLeft.updateBlockFlagsCh:
-				evt.user = m.user
-				log.Debugf("send update block: %v", evt)
-				go m.app.Send(m.user, evt)
-			case evt := <-m.tableRight.updateBlockFlagsCh:
-				evt.user = m.user
-				log.Infof("send update block: %v", evt)
-				go m.app.Send(m.user, evt)
+	// forward updates of our own table to the room, other players forward
+	// theirs
+	table := m.app.tableRepo.FindByPlayer(m.user)
+	if table != nil {
+		go func() {
+			for {
+				select {
+				case <-m.done:
+					return
+				case <-m.sessionDone:
+					return
+				case evt := <-table.updateBlockFlagsCh:
+					evt.user = m.user
+					log.Debugf("send update block: %v", evt)
+					go m.app.Send(m.user, evt)
+				}
 			}
-		}
-	}()
+		}()
+	}
 
-	return tea.Batch(tickCmd(), m.timer.Init())
+	cmds := []tea.Cmd{tickCmd()}
+	// a resumed match has no join messages on their way, replay them
+	if m.resume && exists {
+		for i, player := range r.Players() {
+			join := Join{user: player, index: i}
+			cmds = append(cmds, func() tea.Msg {
+				return join
+			})
+		}
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m *GameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -80,7 +143,10 @@ func (m *GameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.flexBox.SetHeight(msg.Height)
 		m.flexBox.SetWidth(msg.Width)
 	case tickMsg:
-		p := float64(m.timer.Timeout.Microseconds()) / float64(gameDuration.Microseconds())
+		p := 1.0
+		if m.status == RoomPlaying {
+			p = float64(m.remaining().Microseconds()) / float64(m.deadline.Sub(m.startedAt).Microseconds())
+		}
 		cmd := m.timerProgress.SetPercent(p)
 		return m, tea.Batch(tickCmd(), cmd)
 	case progress.FrameMsg:
@@ -88,56 +154,116 @@ func (m *GameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.timerProgress = progressModel.(progress.Model)
 		return m, cmd
 
-	// case BlockFlags:
-	// 	log.Debugf("update block %v", msg)
-	// 	table := m.tableLeft
-	// 	if msg.user == m.userRight {
-	// 		table = m.tableRight
-	// 	}
-	// 	cell := &table.table[msg.row][msg.col]
-	// 	cell.isHovered = msg.isHovered
-	// 	cell.isSelected = msg.isSelected
-	// 	return m, nil
+	case BlockFlags:
+		if o := m.opponent(msg.user); o != nil && o.table != nil {
+			o.table.Apply(msg)
+		}
+		return m, nil
 
 	case Join:
 		log.Infof("new user %s join %d", msg.user, msg.index)
-		if msg.index == 0 {
-			m.userLeft = msg.user
-			m.tableLeft = m.app.tableRepo.FindByPlayer(msg.user)
-		} else if msg.index == 1 {
-			m.userRight = msg.user
-			m.tableRight = m.app.tableRepo.FindByPlayer(msg.user)
+		table := m.app.tableRepo.FindByPlayer(msg.user)
+		if msg.user == m.user {
+			m.table = table
+			return m, nil
+		}
+
+		// opponents are rendered from a mirror kept in sync by BlockFlags
+		var mirror *ArithmeticTable
+		if table != nil {
+			mirror = table.Mirror()
+		}
+		// keep opponents where they are already shown, the index in the
+		// room changes when someone leaves
+		if o := m.opponent(msg.user); o != nil {
+			o.table = mirror
+			return m, nil
 		}
+		m.opponents = append(m.opponents, Opponent{
+			user:  msg.user,
+			name:  m.app.DisplayName(msg.user),
+			table: mirror,
+			away:  m.away[msg.user],
+		})
+		return m, nil
+	case PlayerAway:
+		if m.away == nil {
+			m.away = make(map[string]bool)
+		}
+		m.away[msg.user] = msg.away
+		if o := m.opponent(msg.user); o != nil {
+			o.away = msg.away
+		}
+		return m, nil
+	case Leave:
+		log.Infof("user %s left", msg.user)
+		// another view of the same player left the room
+		if msg.user == m.user && !m.spectating {
+			m.stop()
+			return m, m.gotoRoomPage()
+		}
+		delete(m.ready, msg.user)
+		for i := range m.opponents {
+			if m.opponents[i].user == msg.user {
+				m.opponents = append(m.opponents[:i], m.opponents[i+1:]...)
+				break
+			}
+		}
+		return m, nil
+	case RoomStatusChanged:
+		m.status = msg.status
+		m.startedAt = msg.startedAt
+		m.deadline = msg.deadline
+		m.seed = msg.seed
+		switch msg.status {
+		case RoomWaiting:
+			m.ready = make(map[string]bool)
+		case RoomFinished:
+			m.stop()
+			result := m.result()
+			spectating := m.spectating
+			return m, func() tea.Msg {
+				return GotoRoute{route: ResultRoute{Result: result, Spectating: spectating}}
+			}
+		}
+		return m, nil
+	case RoomClosed:
+		m.stop()
+		return m, m.gotoRoomPage()
+	case Kicked:
+		m.stop()
+		return m, gotoKicked(0, 0, m.app)
+	case Ready:
+		m.ready[msg.user] = true
+		return m, nil
+	case Countdown:
+		m.countdown = msg.n
 		return m, nil
 	case Score:
-		if msg.user == m.userLeft {
-			m.tableLeft.score += msg.delta
-		} else {
-			m.tableRight.score += msg.delta
+		if o := m.opponent(msg.user); o != nil && o.table != nil {
+			o.table.SetScore(msg)
 		}
 		return m, nil
 
-	// TODO: end game
-	case timer.TimeoutMsg:
-		return m, tea.Quit
-
-	case timer.TickMsg:
-		var cmd tea.Cmd
-		m.timer, cmd = m.timer.Update(msg)
-		return m, cmd
-
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return m, tea.Quit
 		}
 
-		table := m.tableLeft
-		if m.user == m.userRight {
-			table = m.tableRight
+		if m.spectating {
+			if key.Matches(msg, m.keymap.leave) {
+				return m, m.leave()
+			}
+			return m, nil
 		}
 
-		// TODO: ignore if game has not started
+		if m.status != RoomPlaying || m.table == nil {
+			return m.updateLobby(msg)
+		}
+
+		table := m.table
+
 		switch {
 		case key.Matches(msg, m.keymap.up):
 			table.CursorUp()
@@ -150,7 +276,7 @@ func (m *GameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keymap.choose):
 			s := table.Toggle()
 			if s != 0 {
-				m.app.Send(m.user, Score{user: m.user, delta: s})
+				m.app.Send(m.user, table.scoreUpdate(m.user, s))
 			}
 		}
 	}
@@ -158,9 +284,205 @@ func (m *GameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// updateLobby handles keys before the match starts.
+func (m *GameModel) updateLobby(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keymap.ready) && m.status == RoomReadyCheck:
+		if err := m.app.Ready(m.user); err != nil {
+			log.Error(err)
+		}
+	case key.Matches(msg, m.keymap.leave) && (m.status == RoomWaiting || m.status == RoomReadyCheck):
+		return m, m.leave()
+	case key.Matches(msg, m.keymap.addBot) && m.status == RoomWaiting:
+		r, exists := m.app.RoomOf(m.user)
+		if !exists {
+			return m, nil
+		}
+		if err := m.app.AddBot(r, botSkills[m.botSkill]); err != nil {
+			log.Error(err)
+		}
+	case key.Matches(msg, m.keymap.skill) && m.status == RoomWaiting:
+		m.botSkill = (m.botSkill + 1) % len(botSkills)
+	case key.Matches(msg, m.keymap.target) && len(m.opponents) != 0:
+		m.kickTarget = (m.kickTarget + 1) % len(m.opponents)
+	case key.Matches(msg, m.keymap.kick) && m.kickTarget < len(m.opponents):
+		if err := m.app.Kick(m.user, m.opponents[m.kickTarget].user); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *GameModel) opponent(user string) *Opponent {
+	for i := range m.opponents {
+		if m.opponents[i].user == user {
+			return &m.opponents[i]
+		}
+	}
+	return nil
+}
+
+// stop stops forwarding block updates, it is safe to call more than once.
+func (m *GameModel) stop() {
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+}
+
+func (m *GameModel) leave() tea.Cmd {
+	m.stop()
+	m.app.LeaveRoom(m.user)
+	return m.gotoRoomPage()
+}
+
+func (m *GameModel) gotoRoomPage() tea.Cmd {
+	page := NewRoomPage(0, 0, m.app)
+	return func() tea.Msg {
+		return GotoRoute{route: StaticRoute{Model: page}}
+	}
+}
+
+func (m *GameModel) result() MatchResult {
+	result := MatchResult{
+		players:  make([]string, 0, len(m.opponents)+1),
+		scores:   make([]int, 0, len(m.opponents)+1),
+		duration: m.deadline.Sub(m.startedAt),
+		seed:     m.seed,
+		practice: m.practice,
+		best:     m.best,
+		hasBest:  m.hasBest,
+	}
+
+	if m.table != nil {
+		result.add(m.user, m.table)
+	}
+	for _, o := range m.opponents {
+		// prefer the real table over the mirror, the last messages may still
+		// be on their way
+		table := m.app.tableRepo.FindByPlayer(o.user)
+		if table == nil {
+			table = o.table
+		}
+		if table != nil {
+			result.add(o.user, table)
+		}
+	}
+
+	return result
+}
+
+// renderOpponents renders opponents as full boards, mini-boards or score
+// cards, whichever is the most detailed one fitting in width x height.
+func renderOpponents(opponents []Opponent, width, height int) string {
+	if len(opponents) == 0 {
+		return "[empty]"
+	}
+
+	renderers := []func(Opponent) string{
+		Opponent.renderBoard,
+		Opponent.renderMini,
+		Opponent.renderCard,
+	}
+
+	content := ""
+	for _, render := range renderers {
+		views := make([]string, 0, len(opponents))
+		for _, o := range opponents {
+			views = append(views, render(o))
+		}
+
+		content = arrange(views, width)
+		if lipgloss.Width(content) <= width && lipgloss.Height(content) <= height {
+			break
+		}
+	}
+
+	return content
+}
+
+// arrange joins views into rows no wider than width.
+func arrange(views []string, width int) string {
+	rows := make([]string, 0)
+	row := make([]string, 0)
+	for _, v := range views {
+		if len(row) != 0 && lipgloss.Width(lipgloss.JoinHorizontal(lipgloss.Top, append(row, v)...)) > width {
+			rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+			row = make([]string, 0)
+		}
+		row = append(row, v)
+	}
+	rows = append(rows, lipgloss.JoinHorizontal(lipgloss.Top, row...))
+
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
+// playerCount returns how many players are in the room, spectators see every
+// player as an opponent.
+func (m *GameModel) playerCount() int {
+	if m.spectating {
+		return len(m.opponents)
+	}
+	return len(m.opponents) + 1
+}
+
+func (m *GameModel) renderHeader() string {
+	switch m.status {
+	case RoomWaiting:
+		header := fmt.Sprintf("waiting for players (%d/%d)...", m.playerCount(), m.capacity)
+		if !m.spectating {
+			header += fmt.Sprintf(" b: add %s bot", botSkills[m.botSkill].Name)
+		}
+		return header + m.renderLobbyInfo()
+	case RoomReadyCheck:
+		if m.practice != "" {
+			return "practice: press r to start"
+		}
+		header := fmt.Sprintf("press r when ready (%d/%d ready)", len(m.ready), m.capacity)
+		if m.spectating {
+			header = fmt.Sprintf("waiting for players to be ready (%d/%d ready)", len(m.ready), m.capacity)
+		} else if m.ready[m.user] {
+			header = fmt.Sprintf("waiting for others to be ready (%d/%d ready)", len(m.ready), m.capacity)
+		}
+		return header + m.renderLobbyInfo()
+	case RoomCountdown:
+		return theme().blockHovered.Render(fmt.Sprintf("%d", m.countdown))
+	default:
+		if m.table == nil {
+			return m.renderTimer()
+		}
+		return fmt.Sprintf("%s  %s", m.renderTimer(), m.table.Breakdown())
+	}
+}
+
+// renderLobbyInfo shows the invite code, and who would be kicked to the host.
+func (m *GameModel) renderLobbyInfo() string {
+	info := fmt.Sprintf(" | code: %s", m.code)
+	if m.spectating || len(m.opponents) == 0 {
+		return info
+	}
+	if r, exists := m.app.RoomOf(m.user); exists && r.Host() == m.user {
+		if m.kickTarget >= len(m.opponents) {
+			m.kickTarget = 0
+		}
+		info += fmt.Sprintf(" | x: kick %s", m.opponents[m.kickTarget].name)
+	}
+	return info
+}
+
+func (m *GameModel) remaining() time.Duration {
+	d := time.Until(m.deadline)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
 func (m *GameModel) renderTimer() string {
 	prog := m.timerProgress.View()
-	time := m.timer.Timeout.Seconds()
+	time := m.remaining().Seconds()
 
 	return fmt.Sprintf("%s %.2fs", prog, time)
 }
@@ -169,7 +491,7 @@ func (m *GameModel) View() string {
 	m.flexBox.ForceRecalculate()
 	row0 := m.flexBox.GetRow(0)
 	headerCell := row0.GetCell(0)
-	headerCell.SetContent(m.renderTimer())
+	headerCell.SetContent(m.renderHeader())
 
 	row1 := m.flexBox.GetRow(1)
 	tableCell := row1.GetCell(0)
@@ -180,46 +502,104 @@ func (m *GameModel) View() string {
 			m.keymap.left,
 			m.keymap.right,
 		},
-		{m.keymap.choose},
+		{m.keymap.choose, m.keymap.ready, m.keymap.leave},
+		{m.keymap.addBot, m.keymap.skill, m.keymap.kick, m.keymap.target},
 	})
-	leftContent := "[empty]"
-	if m.tableLeft != nil {
-		leftContent = m.tableLeft.Render()
-	}
-	tableCell.SetContent(lipgloss.JoinVertical(
-		lipgloss.Top,
-		leftContent,
-	))
-	tableCell = row1.GetCell(2)
-	rightContent := "[empty]"
-	if m.tableRight != nil {
-		rightContent = m.tableRight.Render()
-	}
-	tableCell.SetContent(rightContent)
-
-	// // debug
-	// userLeft := fmt.Sprintf("user left:  %s", m.userLeft)
-	// userRight := fmt.Sprintf("user right: %s", m.userRight)
-
-	// if m.user == userLeft {
-	// 	userLeft = styleBlockSelected.Render(userLeft)
-	// }
-	// if m.user == userRight {
-	// 	userRight = styleBlockSelected.Render(userRight)
-	// }
-
-	// help += "\n"
-	// help += fmt.Sprintf("user: %s", m.user)
-	// help += "\n"
-	// help += userLeft
-	// help += "\n"
-	// help += userRight
+	opponents := m.opponents
+	content := "[empty]"
+	if m.table != nil {
+		content = m.table.Render()
+		// large difficulties may not fit, fall back to the compact board
+		if lipgloss.Width(content) > tableCell.GetWidth() || lipgloss.Height(content) > tableCell.GetHeight() {
+			content = m.table.RenderMini()
+		}
+	}
+	if m.spectating {
+		help = m.help.ShortHelpView([]key.Binding{m.keymap.leave})
+		// spectators have no board of their own, show the first player
+		// large instead
+		if len(opponents) > 0 {
+			content = opponents[0].renderBoard()
+			opponents = opponents[1:]
+		}
+	}
+	tableCell.SetContent(content)
+
+	opponentsCell := row1.GetCell(1)
+	if m.practice != "" {
+		opponentsCell.SetContent(m.renderPractice())
+	} else {
+		opponentsCell.SetContent(renderOpponents(opponents, opponentsCell.GetWidth(), opponentsCell.GetHeight()))
+	}
 
 	m.flexBox.GetRow(2).GetCell(0).SetContent(help)
 
 	return m.flexBox.Render()
 }
 
+// renderPractice shows the personal best the player is racing against.
+func (m *GameModel) renderPractice() string {
+	best := "personal best: -"
+	if m.hasBest {
+		best = fmt.Sprintf("personal best: %d", m.best)
+	}
+	return lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1).Render(
+		fmt.Sprintf("practice (%s)\n%s", m.practice, best),
+	)
+}
+
+type Opponent struct {
+	user  string
+	name  string
+	table *ArithmeticTable
+	// the connection of the opponent dropped, it may come back
+	away bool
+}
+
+func (o Opponent) title() string {
+	if o.away {
+		return o.name + " (reconnecting…)"
+	}
+	return o.name
+}
+
+func (o Opponent) renderBoard() string {
+	if o.table == nil {
+		return "[empty]"
+	}
+	return lipgloss.NewStyle().Padding(0, 1).Render(
+		lipgloss.JoinVertical(lipgloss.Left, o.title(), o.table.Render()),
+	)
+}
+
+func (o Opponent) renderMini() string {
+	if o.table == nil {
+		return "[empty]"
+	}
+	return lipgloss.NewStyle().Padding(0, 1).Render(
+		lipgloss.JoinVertical(lipgloss.Left, o.title(), o.table.RenderMini()),
+	)
+}
+
+func (o Opponent) renderCard() string {
+	score := 0
+	if o.table != nil {
+		score = o.table.Score()
+	}
+	return lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1).Render(
+		fmt.Sprintf("%s\nscore: %d", o.title(), score),
+	)
+}
+
+// shortName shortens a key fingerprint for places too small to show it.
+func shortName(user string) string {
+	name := strings.TrimPrefix(user, "SHA256:")
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return name
+}
+
 type Route interface {
 	GetModel() tea.Model
 }
@@ -240,21 +620,29 @@ type Router interface {
 }
 
 type AppRouter struct {
-	app   *App
-	user  string
-	route Route
-	model tea.Model
+	app     *App
+	user    string
+	session *Session
+	route   Route
+	model   tea.Model
+	// closed when the session ends
+	done <-chan struct{}
 }
 
 func (ar *AppRouter) Goto(r Route) error {
 	ar.route = r
 	m := r.GetModel()
 
+	// only the room list wants to hear about room changes
+	_, watching := m.(*RoomPage)
+	ar.app.WatchRooms(ar.session, watching)
+
 	// TODO: DI
 	switch m := m.(type) {
 	case *GameModel:
 		m.app = ar.app
 		m.user = ar.user
+		m.sessionDone = ar.done
 		ar.model = m
 		return nil
 	case *RoomPage:
@@ -262,6 +650,31 @@ func (ar *AppRouter) Goto(r Route) error {
 		m.user = ar.user
 		ar.model = m
 		return nil
+	case *ResultPage:
+		m.app = ar.app
+		m.user = ar.user
+		ar.model = m
+		return nil
+	case *OnboardingPage:
+		m.app = ar.app
+		m.user = ar.user
+		ar.model = m
+		return nil
+	case *LeaderboardPage:
+		m.app = ar.app
+		m.user = ar.user
+		ar.model = m
+		return nil
+	case *QueuePage:
+		m.app = ar.app
+		m.user = ar.user
+		ar.model = m
+		return nil
+	case *SessionChoicePage:
+		m.app = ar.app
+		m.user = ar.user
+		ar.model = m
+		return nil
 	default:
 		ar.model = m
 		return nil
@@ -297,6 +710,18 @@ type AppModel struct {
 	router Router
 	height int
 	width  int
+	// set once the server announced it stops
+	drainDeadline time.Time
+	// set once another session of the same key replaced this one
+	takenOver bool
+}
+
+type drainTickMsg struct{}
+
+func drainTickCmd() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return drainTickMsg{}
+	})
 }
 
 func NewGameModel() GameModel {
@@ -306,14 +731,21 @@ func NewGameModel() GameModel {
 		left:   key.NewBinding(key.WithKeys("left"), key.WithHelp("←", "left")),
 		right:  key.NewBinding(key.WithKeys("right"), key.WithHelp("→", "right")),
 		choose: key.NewBinding(key.WithKeys(tea.KeySpace.String()), key.WithHelp("space", "(un)select")),
+		ready:  key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "ready")),
+		leave:  key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "leave room")),
+		addBot: key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "add bot")),
+		skill:  key.NewBinding(key.WithKeys("k"), key.WithHelp("k", "bot skill")),
+		kick:   key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "kick")),
+		target: key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "kick whom")),
 	}
 
 	m := GameModel{
 		flexBox:       flexbox.New(0, 0),
-		timer:         timer.NewWithInterval(gameDuration, time.Millisecond),
 		timerProgress: progress.New(progress.WithDefaultGradient(), progress.WithoutPercentage()),
 		keymap:        keymap,
 		help:          help.New(),
+		done:          make(chan struct{}),
+		ready:         make(map[string]bool),
 	}
 
 	flexRows := make([]*flexbox.Row, 0)
@@ -325,9 +757,8 @@ func NewGameModel() GameModel {
 
 	styleMathTable := lipgloss.NewStyle().Align(lipgloss.Center, lipgloss.Center)
 	row1 := m.flexBox.NewRow().AddCells(
-		flexbox.NewCell(4, 6).SetStyle(styleMathTable),
-		flexbox.NewCell(2, 6).SetStyle(styleMathTable),
-		flexbox.NewCell(4, 6).SetStyle(styleMathTable),
+		flexbox.NewCell(5, 6).SetStyle(styleMathTable),
+		flexbox.NewCell(5, 6).SetStyle(styleMathTable),
 	)
 	flexRows = append(flexRows, row1)
 
@@ -342,23 +773,45 @@ func NewGameModel() GameModel {
 	return m
 }
 
-func NewAppModel(user string, app *App) AppModel {
+// NewAppModel returns the root model of session, done is closed when the
+// session ends.
+func NewAppModel(session *Session, app *App, done <-chan struct{}) AppModel {
 	return AppModel{
-		user: user,
+		user: session.user,
 		app:  app,
 		router: &AppRouter{
-			user: user,
-			app:  app,
+			user:    session.user,
+			app:     app,
+			session: session,
+			done:    done,
 		},
 	}
 }
 
-func genTable() [][]ArithmeticBlock {
-	mathRows := make([][]ArithmeticBlock, 0)
-	for i := 0; i < 4; i++ {
-		r := make([]ArithmeticBlock, 0)
-		for j := 0; j < 3; j++ {
-			r = append(r, NewArithmeticBlock(1+rand.Intn(13)))
+// genValues returns n random values containing at least pairs pairs of equal values.
+func genValues(rng *rand.Rand, difficulty Difficulty, pairs int) []int {
+	n := difficulty.Rows * difficulty.Cols
+	values := make([]int, 0, n)
+	for i := 0; i < pairs && len(values)+2 <= n; i++ {
+		v := difficulty.value(rng)
+		values = append(values, v, v)
+	}
+	for len(values) < n {
+		values = append(values, difficulty.value(rng))
+	}
+	rng.Shuffle(len(values), func(i, j int) {
+		values[i], values[j] = values[j], values[i]
+	})
+	return values
+}
+
+func genTable(values, shapes *rand.Rand, difficulty Difficulty, operators OperatorSet) [][]ArithmeticBlock {
+	vals := genValues(values, difficulty, minPairs)
+	mathRows := make([][]ArithmeticBlock, 0, difficulty.Rows)
+	for i := 0; i < difficulty.Rows; i++ {
+		r := make([]ArithmeticBlock, 0, difficulty.Cols)
+		for j := 0; j < difficulty.Cols; j++ {
+			r = append(r, NewArithmeticBlock(shapes, vals[i*difficulty.Cols+j], operators.Pick(shapes)))
 		}
 		mathRows = append(mathRows, r)
 	}
@@ -383,6 +836,18 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return tea.WindowSizeMsg{Height: m.height, Width: m.width}
 		}
 		return m, tea.Sequence([]tea.Cmd{cmd, resizeChild}...)
+	case SessionTakenOver:
+		m.takenOver = true
+		// leave the alt screen so the goodbye stays on the terminal
+		return m, tea.Sequence(tea.ExitAltScreen, tea.Quit)
+	case ServerDraining:
+		m.drainDeadline = msg.deadline
+		return m, drainTickCmd()
+	case drainTickMsg:
+		if time.Now().After(m.drainDeadline) {
+			return m, nil
+		}
+		return m, drainTickCmd()
 	}
 
 	rm, cmd := m.router.Update(msg)
@@ -392,5 +857,17 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m AppModel) View() string {
-	return m.router.View()
+	if m.takenOver {
+		return "Signed in from another session, bye!\n"
+	}
+	if m.drainDeadline.IsZero() {
+		return m.router.View()
+	}
+
+	left := time.Until(m.drainDeadline).Round(time.Second)
+	if left < 0 {
+		left = 0
+	}
+	banner := theme().blockSelected.Width(m.width).Render(fmt.Sprintf("server restarting within %s, no new matches can be started", left))
+	return lipgloss.JoinVertical(lipgloss.Left, banner, m.router.View())
 }
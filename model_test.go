@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderCountsPlayers(t *testing.T) {
+	opponents := []Opponent{{user: "a"}, {user: "b"}}
+	tests := map[string]struct {
+		spectating bool
+		status     RoomStatus
+		want       string
+		unwanted   string
+	}{
+		"player waiting":    {status: RoomWaiting, want: "(3/4)"},
+		"spectator waiting": {spectating: true, status: RoomWaiting, want: "(2/4)"},
+		"player ready":      {status: RoomReadyCheck, want: "press r"},
+		"spectator ready":   {spectating: true, status: RoomReadyCheck, want: "(1/4 ready)", unwanted: "press r"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := NewGameModel()
+			m.app = newTestApp(t)
+			m.user = "c"
+			m.spectating = tt.spectating
+			m.opponents = opponents
+			m.capacity = 4
+			m.status = tt.status
+			m.ready = map[string]bool{"a": true}
+
+			header := m.renderHeader()
+			if !strings.Contains(header, tt.want) {
+				t.Errorf("header %q does not contain %q", header, tt.want)
+			}
+			if tt.unwanted != "" && strings.Contains(header, tt.unwanted) {
+				t.Errorf("header %q contains %q", header, tt.unwanted)
+			}
+		})
+	}
+}
@@ -2,70 +2,206 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 )
 
 type RoomListItem struct {
 	room *Room
+	// display name of the host when the list was built
+	host string
 }
 
+// FilterValue lets the list filter rooms by any of their metadata.
 func (it *RoomListItem) FilterValue() string {
-	return strconv.Itoa(it.room.id)
+	return strings.Join([]string{
+		strconv.Itoa(it.room.id),
+		it.room.name,
+		it.host,
+		it.room.mode.String(),
+		it.room.difficulty.String(),
+		it.room.Status().String(),
+	}, " ")
 }
 
 func (it *RoomListItem) Title() string {
-	return fmt.Sprintf("Room #%d", it.room.id)
+	return fmt.Sprintf("%s (#%d, %s)", it.room.name, it.room.id, it.room.mode)
 }
 
 func (it *RoomListItem) Description() string {
-	return fmt.Sprintf("%d / 2 players.", len(it.room.players))
+	desc := fmt.Sprintf("%d / %d players, %s. host: %s. %s, operators: %s. created %s ago.",
+		len(it.room.Players()),
+		it.room.capacity,
+		it.room.Status(),
+		it.host,
+		it.room.difficulty,
+		it.room.operators,
+		time.Since(it.room.createdAt).Truncate(time.Second),
+	)
+	if n := len(it.room.Spectators()); n != 0 {
+		desc += fmt.Sprintf(" %d watching.", n)
+	}
+	return desc
+}
+
+func roomPageHelp() []key.Binding {
+	return []key.Binding{
+		key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "new room")),
+		key.NewBinding(key.WithKeys("N"), key.WithHelp("N", "new private room")),
+		key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "join by code")),
+		key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "quick match")),
+		key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "practice")),
+		key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "join")),
+		key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "spectate")),
+		key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "difficulty")),
+		key.NewBinding(key.WithKeys("o"), key.WithHelp("o", "operators")),
+		key.NewBinding(key.WithKeys("c"), key.WithHelp("c", "capacity")),
+		key.NewBinding(key.WithKeys("l"), key.WithHelp("l", "leaderboard")),
+		key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "refresh")),
+	}
 }
 
 type RoomPage struct {
 	app  *App
 	user string
 
-	repo   RoomRepository
 	height int
 	width  int
 
+	// settings of new rooms, difficulty is an index of difficulties and
+	// operators of operatorSets, offset by one as 0 keeps the operators of
+	// the difficulty
+	difficulty int
+	operators  int
+	capacity   int
+
+	// typing an invite code
+	joining bool
+	code    textinput.Model
+	// runs when the page starts, e.g. joining the room of `ssh ... join CODE`
+	initCmd tea.Cmd
+
 	rooms list.Model
 }
 
-// TODO: inject repo
-func NewRoomPage(height, width int, repo RoomRepository) *RoomPage {
-	rawRooms := repo.List()
-	items := make([]list.Item, 0, len(rawRooms))
-	for _, r := range rawRooms {
-		items = append(items, &RoomListItem{room: r})
+func NewRoomPage(height, width int, app *App) *RoomPage {
+	rooms := list.New(roomItems(app), list.NewDefaultDelegate(), width, height)
+	rooms.AdditionalShortHelpKeys = roomPageHelp
+
+	cfg := app.Config()
+	difficulty, _ := difficultyIndex(cfg.Board.Difficulty)
+	p := &RoomPage{
+		app:        app,
+		height:     height,
+		width:      width,
+		rooms:      rooms,
+		capacity:   cfg.Board.Capacity,
+		difficulty: difficulty,
+		code:       newCodeInput(),
 	}
-	rooms := list.New(items, list.NewDefaultDelegate(), width, height)
-	rooms.Title = "Rooms"
+	p.updateTitle()
+	return p
+}
 
-	return &RoomPage{
-		repo:   repo,
-		height: height,
-		width:  width,
-		rooms:  rooms,
+func (p *RoomPage) updateTitle() {
+	p.rooms.Title = fmt.Sprintf("Rooms (new room: %d players, %s, operators: %s)", p.capacity, difficulties[p.difficulty], p.selectedOperators())
+}
+
+func (p *RoomPage) selectedOperators() OperatorSet {
+	if p.operators == 0 {
+		return difficulties[p.difficulty].Operators
 	}
+	return operatorSets[p.operators-1]
 }
 
 func (p *RoomPage) refreshRooms() tea.Cmd {
-	rawRooms := p.repo.List()
+	return p.rooms.SetItems(roomItems(p.app))
+}
+
+func newCodeInput() textinput.Model {
+	input := textinput.New()
+	input.Placeholder = "invite code"
+	input.CharLimit = inviteCodeLength
+	return input
+}
+
+// roomItems lists the rooms others can join or watch, practice and private
+// rooms are not listed.
+func roomItems(app *App) []list.Item {
+	rawRooms := app.roomRepo.List()
 	items := make([]list.Item, 0, len(rawRooms))
 	for _, r := range rawRooms {
-		items = append(items, &RoomListItem{room: r})
+		if !r.listed() {
+			continue
+		}
+		items = append(items, &RoomListItem{room: r, host: app.DisplayName(r.Host())})
 	}
-	return p.rooms.SetItems(items)
+	return items
 }
 
 func (p *RoomPage) Init() tea.Cmd {
-	return nil
+	return p.initCmd
+}
+
+// joinByCode joins the room with the invite code, or tells why it can not.
+func (p *RoomPage) joinByCode(code string) tea.Cmd {
+	room, err := p.app.JoinByCode(p.user, code)
+	if err != nil {
+		log.Warn("failed to join by code", "code", code, "error", err)
+		return p.rooms.NewStatusMessage(fmt.Sprintf("can not join %s: %s", code, err))
+	}
+	return startGame(room)
+}
+
+// hostRoom creates a room with the settings of the page and joins it.
+func (p *RoomPage) hostRoom(mode RoomMode, private bool) tea.Cmd {
+	room, err := p.app.HostRoom(p.user, RoomOptions{
+		Name:       fmt.Sprintf("%s's room", p.app.DisplayName(p.user)),
+		Mode:       mode,
+		Difficulty: difficulties[p.difficulty],
+		Operators:  p.selectedOperators(),
+		Capacity:   p.capacity,
+		Private:    private,
+	})
+	if err != nil {
+		log.Error(err)
+		return p.rooms.NewStatusMessage(fmt.Sprintf("can not create room: %s", err))
+	}
+
+	return startGame(room)
+}
+
+func (p *RoomPage) stopJoining() {
+	p.joining = false
+	p.code.Blur()
+	p.rooms.SetHeight(p.height)
+}
+
+// updateCode handles keys while typing an invite code.
+func (p *RoomPage) updateCode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return p, tea.Quit
+	case tea.KeyEsc:
+		p.stopJoining()
+		return p, nil
+	case tea.KeyEnter:
+		p.stopJoining()
+		return p, p.joinByCode(p.code.Value())
+	}
+
+	var cmd tea.Cmd
+	p.code, cmd = p.code.Update(msg)
+	return p, cmd
 }
 
 func (p *RoomPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -77,47 +213,72 @@ func (p *RoomPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		p.width = msg.Width
 		p.rooms.SetHeight(msg.Height)
 		p.rooms.SetWidth(msg.Width)
+	case RoomListChanged:
+		log.Debugf("room %d %s", msg.event.Room.id, msg.event.Kind)
+		return p, p.refreshRooms()
 	case tea.KeyMsg:
+		if p.joining {
+			return p.updateCode(msg)
+		}
+
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return p, tea.Quit
 		case "n":
-			var room *Room
-			for i := 0; i < 5; i++ {
-				var err error
-				room, err = p.repo.Create(rand.Intn(100))
-				if err == nil {
-					log.Infof("add new room: %d", room.id)
-					break
-				}
-
-				err = fmt.Errorf("failed to add room: %w", err)
-				log.Warn(err)
-				continue
-			}
-
-			if room == nil {
-				// TODO: error handling
-				return p, nil
+			return p, p.hostRoom(RoomModeCustom, false)
+		case "N":
+			return p, p.hostRoom(RoomModeCustom, true)
+		case "i":
+			p.joining = true
+			p.code.Reset()
+			// make room for the input above the list
+			p.rooms.SetHeight(p.height - 1)
+			return p, p.code.Focus()
+		case "m":
+			if err := p.app.QuickMatch(p.user); err != nil {
+				log.Error(err)
+				return p, p.rooms.NewStatusMessage(err.Error())
 			}
 
-			_, err := p.app.tableRepo.Create(p.user)
-			if err != nil {
-				log.Error(err)
+			page := NewQueuePage(p.height, p.width)
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
+			}
+		case "p":
+			return p, p.hostRoom(RoomModePractice, true)
+		case "o":
+			p.operators = (p.operators + 1) % (len(operatorSets) + 1)
+			p.updateTitle()
+			return p, nil
+		case "d":
+			p.difficulty = (p.difficulty + 1) % len(difficulties)
+			p.updateTitle()
+			return p, nil
+		case "s":
+			item, ok := p.rooms.SelectedItem().(*RoomListItem)
+			if !ok {
+				log.Info("no room selected")
 				return p, nil
 			}
 
-			gm := NewGameModel()
-			p.app.playerToRoom[p.user] = room
-			index := room.Join(p.user)
+			if err := p.app.Spectate(p.user, item.room); err != nil {
+				log.Error(err)
+				return p, p.rooms.NewStatusMessage(err.Error())
+			}
 
-			join := func() tea.Msg {
-				return Join{user: p.user, index: index}
+			return p, watchGame(item.room)
+		case "l":
+			page := NewLeaderboardPage()
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
 			}
-			gotoRoute := func() tea.Msg {
-				return GotoRoute{route: StaticRoute{Model: &gm}}
+		case "c":
+			p.capacity++
+			if p.capacity > maxRoomCapacity {
+				p.capacity = minRoomCapacity
 			}
-			return p, tea.Sequence(gotoRoute, join)
+			p.updateTitle()
+			return p, nil
 		case "r":
 			cmd := p.refreshRooms()
 			cmds = append(cmds, cmd)
@@ -129,48 +290,686 @@ func (p *RoomPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return p, nil
 			}
 
-			_, err := p.app.tableRepo.Create(p.user)
-			if err != nil {
+			room := item.room
+			if _, err := p.app.JoinRoom(p.user, room); err != nil {
 				log.Error(err)
-				return p, nil
+				return p, p.rooms.NewStatusMessage(err.Error())
 			}
 
-			room := item.room
-			gm := NewGameModel()
-			p.app.playerToRoom[p.user] = room
+			return p, startGame(room)
+		}
+	}
 
-			cmds := make([]tea.Cmd, 0)
-			cmds = append(cmds, func() tea.Msg {
-				return GotoRoute{route: StaticRoute{Model: &gm}}
-			})
+	var cmd tea.Cmd
+	p.rooms, cmd = p.rooms.Update(msg)
+	cmds = append(cmds, cmd)
+
+	return p, tea.Batch(cmds...)
+}
+
+func (p *RoomPage) View() string {
+	view := p.rooms.View()
+	if p.joining {
+		view = lipgloss.JoinVertical(lipgloss.Left, "Join by invite code: "+p.code.View(), view)
+	}
+	if motd := p.app.Config().MOTD; motd != "" {
+		view = lipgloss.JoinVertical(lipgloss.Left, theme().blockHovered.Render(motd), view)
+	}
+	return view
+}
+
+type MatchResult struct {
+	players    []string
+	scores     []int
+	breakdowns []ScoreBreakdown
+	duration   time.Duration
+	// seed of the tables, the match can be replayed from it
+	seed int64
+	// aborted is set for matches cut short by a shutdown
+	aborted bool
+
+	// practice is the key of personal bests for practice runs, empty for
+	// matches. best is the personal best before the run.
+	practice string
+	best     int
+	hasBest  bool
+}
+
+// add appends the score of player from its table.
+func (r *MatchResult) add(player string, t *ArithmeticTable) {
+	r.players = append(r.players, player)
+	r.scores = append(r.scores, t.Score())
+	r.breakdowns = append(r.breakdowns, t.Breakdown())
+}
+
+// Winners returns players with the highest score, more than one means a draw.
+func (r MatchResult) Winners() []string {
+	best := 0
+	for _, s := range r.scores {
+		if s > best {
+			best = s
+		}
+	}
+
+	winners := make([]string, 0)
+	for i, s := range r.scores {
+		if s == best {
+			winners = append(winners, r.players[i])
+		}
+	}
+	return winners
+}
 
-			for i, player := range room.players {
-				cmds = append(cmds, func() tea.Msg {
-					return Join{user: player, index: i}
-				})
+type ResultRoute struct {
+	Result     MatchResult
+	Spectating bool
+}
+
+func (r ResultRoute) GetModel() tea.Model {
+	return NewResultPage(r.Result, r.Spectating)
+}
+
+type resultKeymap struct {
+	rematch key.Binding
+	back    key.Binding
+	quit    key.Binding
+}
+
+type ResultPage struct {
+	app  *App
+	user string
+
+	result     MatchResult
+	spectating bool
+	// players who asked for a rematch
+	rematch map[string]bool
+	// players who left the room
+	left map[string]bool
+
+	height int
+	width  int
+
+	keymap resultKeymap
+	help   help.Model
+}
+
+func NewResultPage(result MatchResult, spectating bool) *ResultPage {
+	p := &ResultPage{
+		result:     result,
+		spectating: spectating,
+		rematch:    make(map[string]bool),
+		left:       make(map[string]bool),
+		keymap: resultKeymap{
+			rematch: key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "rematch")),
+			back:    key.NewBinding(key.WithKeys("enter", "esc"), key.WithHelp("enter", "back to rooms")),
+			quit:    key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+		},
+		help: help.New(),
+	}
+	if result.practice != "" {
+		p.keymap.rematch.SetHelp("r", "play again")
+	}
+	return p
+}
+
+func (p *ResultPage) Init() tea.Cmd {
+	return nil
+}
+
+func (p *ResultPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		p.height = msg.Height
+		p.width = msg.Width
+	case RematchRequested:
+		p.rematch[msg.user] = true
+	case Leave:
+		p.left[msg.user] = true
+	case Rematch:
+		if p.spectating {
+			room, exists := p.app.SpectatedRoom(p.user)
+			if !exists {
+				return p, nil
+			}
+			return p, watchGame(room)
+		}
+
+		room, exists := p.app.RoomOf(p.user)
+		if !exists {
+			return p, nil
+		}
+		return p, startGame(room)
+	case RoomClosed:
+		page := NewRoomPage(p.height, p.width, p.app)
+		return p, func() tea.Msg {
+			return GotoRoute{route: StaticRoute{Model: page}}
+		}
+	case Kicked:
+		return p, gotoKicked(p.height, p.width, p.app)
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, p.keymap.quit):
+			p.app.LeaveRoom(p.user)
+			return p, tea.Quit
+		case key.Matches(msg, p.keymap.back):
+			p.app.LeaveRoom(p.user)
+			page := NewRoomPage(p.height, p.width, p.app)
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
+			}
+		case key.Matches(msg, p.keymap.rematch):
+			if p.spectating || len(p.left) != 0 || p.rematch[p.user] {
+				return p, nil
+			}
+			if err := p.app.Rematch(p.user); err != nil {
+				log.Error(err)
 			}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *ResultPage) View() string {
+	if p.result.practice != "" {
+		return p.viewPractice()
+	}
+
+	lines := []string{"Match over", ""}
+
+	winners := p.result.Winners()
+	for i, player := range p.result.players {
+		name := p.app.DisplayName(player)
+		if player == p.user {
+			name += " (you)"
+		}
+		lines = append(lines, fmt.Sprintf("%-24s %4d  %s", name, p.result.scores[i], p.result.breakdowns[i]))
+	}
+	if i := p.resultIndex(); i != -1 {
+		lines = append(lines, "", p.result.breakdowns[i].Summary())
+	}
+	lines = append(lines, "")
+
+	switch {
+	case len(winners) > 1:
+		lines = append(lines, "Draw!")
+	case len(winners) == 1 && p.spectating:
+		lines = append(lines, fmt.Sprintf("%s wins!", p.app.DisplayName(winners[0])))
+	case len(winners) == 1 && winners[0] == p.user:
+		lines = append(lines, theme().blockHovered.Render("You win!"))
+	case len(winners) == 1:
+		lines = append(lines, "You lose.")
+	}
+	lines = append(lines, fmt.Sprintf("Duration: %.1fs, seed: %d", p.result.duration.Seconds(), p.result.seed), "")
+
+	for player := range p.left {
+		lines = append(lines, fmt.Sprintf("%s left the room", p.app.DisplayName(player)))
+	}
+	for player := range p.rematch {
+		if player != p.user {
+			lines = append(lines, fmt.Sprintf("%s wants a rematch", p.app.DisplayName(player)))
+		}
+	}
+	if p.rematch[p.user] {
+		lines = append(lines, "waiting for opponent to accept rematch...")
+	}
+
+	bindings := []key.Binding{p.keymap.rematch, p.keymap.back, p.keymap.quit}
+	if p.spectating {
+		bindings = bindings[1:]
+	}
+	lines = append(lines, "", p.help.ShortHelpView(bindings))
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
+}
+
+// resultIndex returns the index of the viewer in the result, -1 for
+// spectators.
+func (p *ResultPage) resultIndex() int {
+	for i, player := range p.result.players {
+		if player == p.user {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *ResultPage) viewPractice() string {
+	score := 0
+	if len(p.result.scores) != 0 {
+		score = p.result.scores[0]
+	}
+
+	lines := []string{
+		fmt.Sprintf("Practice over (%s)", p.result.practice),
+		"",
+		fmt.Sprintf("score: %d", score),
+	}
+	if len(p.result.breakdowns) != 0 {
+		b := p.result.breakdowns[0]
+		lines = append(lines, b.String(), b.Summary())
+	}
+	switch {
+	case !p.result.hasBest || score > p.result.best:
+		lines = append(lines, theme().blockHovered.Render("New personal best!"))
+	default:
+		lines = append(lines, fmt.Sprintf("personal best: %d", p.result.best))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Duration: %.1fs", p.result.duration.Seconds()),
+		"",
+		p.help.ShortHelpView([]key.Binding{p.keymap.rematch, p.keymap.back, p.keymap.quit}),
+	)
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
+}
+
+// gotoKicked routes a kicked player back to the room list.
+func gotoKicked(height, width int, app *App) tea.Cmd {
+	page := NewRoomPage(height, width, app)
+	page.initCmd = page.rooms.NewStatusMessage("you were kicked by the host")
+	return func() tea.Msg {
+		return GotoRoute{route: StaticRoute{Model: page}}
+	}
+}
+
+// startGame routes to a new GameModel and joins every player already in room.
+func startGame(room *Room) tea.Cmd {
+	gm := NewGameModel()
+	return gotoGame(&gm, room)
+}
+
+// watchGame routes to a new GameModel showing room to a spectator.
+func watchGame(room *Room) tea.Cmd {
+	gm := NewGameModel()
+	gm.spectating = true
+	return gotoGame(&gm, room)
+}
+
+func gotoGame(gm *GameModel, room *Room) tea.Cmd {
+	cmds := []tea.Cmd{func() tea.Msg {
+		return GotoRoute{route: StaticRoute{Model: gm}}
+	}}
+
+	for i, player := range room.Players() {
+		join := Join{user: player, index: i}
+		cmds = append(cmds, func() tea.Msg {
+			return join
+		})
+	}
+
+	return tea.Sequence(cmds...)
+}
+
+// OnboardingPage asks a new player for a nickname.
+type OnboardingPage struct {
+	app  *App
+	user string
+	// invite code the session was started with, joined once the nickname is
+	// picked
+	joinCode string
+
+	input textinput.Model
+	err   error
+
+	height int
+	width  int
+}
+
+func NewOnboardingPage(joinCode string) *OnboardingPage {
+	input := textinput.New()
+	input.Placeholder = "nickname"
+	input.CharLimit = 16
+	input.Focus()
 
-			index := room.Join(p.user)
-			cmds = append(cmds, func() tea.Msg {
-				// TODO: dispatch to room through app
-				p.app.Send(p.user, Join{
-					user:  p.user,
-					index: index,
-				})
-				return nil
-			})
+	return &OnboardingPage{input: input, joinCode: joinCode}
+}
+
+func (p *OnboardingPage) Init() tea.Cmd {
+	return textinput.Blink
+}
 
-			return p, tea.Sequence(cmds...)
+func (p *OnboardingPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		p.height = msg.Height
+		p.width = msg.Width
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return p, tea.Quit
+		case tea.KeyEnter:
+			player, err := p.app.players.Create(p.user, strings.TrimSpace(p.input.Value()))
+			if err != nil {
+				p.err = err
+				return p, nil
+			}
+
+			log.Infof("new player %s: %s", player.Name, p.user)
+			page := NewRoomPage(p.height, p.width, p.app)
+			if p.joinCode != "" {
+				// the router sets the user too late for joining right away
+				page.user = p.user
+				page.initCmd = page.joinByCode(p.joinCode)
+			}
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
+			}
 		}
 	}
 
 	var cmd tea.Cmd
-	p.rooms, cmd = p.rooms.Update(msg)
-	cmds = append(cmds, cmd)
+	p.input, cmd = p.input.Update(msg)
+	return p, cmd
+}
 
-	return p, tea.Batch(cmds...)
+func (p *OnboardingPage) View() string {
+	lines := []string{
+		"Welcome to Click the Same!",
+		"",
+		"Pick a nickname:",
+		p.input.View(),
+		"",
+	}
+	if p.err != nil {
+		lines = append(lines, theme().blockHovered.Render(p.err.Error()), "")
+	}
+	lines = append(lines, "enter confirm • esc quit")
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
 }
 
-func (p *RoomPage) View() string {
-	return p.rooms.View()
+type leaderboardKeymap struct {
+	period key.Binding
+	sort   key.Binding
+	back   key.Binding
+}
+
+// LeaderboardPage shows the top players of a period.
+type LeaderboardPage struct {
+	app  *App
+	user string
+
+	period int
+	sortBy int
+
+	height int
+	width  int
+
+	keymap leaderboardKeymap
+	help   help.Model
+}
+
+func NewLeaderboardPage() *LeaderboardPage {
+	return &LeaderboardPage{
+		keymap: leaderboardKeymap{
+			period: key.NewBinding(key.WithKeys("tab", "right", "left"), key.WithHelp("tab", "period")),
+			sort:   key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "sort")),
+			back:   key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "back")),
+		},
+		help: help.New(),
+	}
+}
+
+func (p *LeaderboardPage) Init() tea.Cmd {
+	return nil
+}
+
+func (p *LeaderboardPage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		p.height = msg.Height
+		p.width = msg.Width
+	case tea.KeyMsg:
+		switch {
+		case msg.String() == "ctrl+c":
+			return p, tea.Quit
+		case key.Matches(msg, p.keymap.period):
+			p.period = (p.period + 1) % len(leaderboardPeriods)
+		case key.Matches(msg, p.keymap.sort):
+			p.sortBy = (p.sortBy + 1) % len(leaderboardSorts)
+		case key.Matches(msg, p.keymap.back):
+			page := NewRoomPage(p.height, p.width, p.app)
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *LeaderboardPage) View() string {
+	period := leaderboardPeriods[p.period]
+	sortBy := leaderboardSorts[p.sortBy]
+	board := BuildLeaderboard(p.app.matches.Since(period.Since(time.Now())), sortBy)
+
+	tabs := make([]string, 0, len(leaderboardPeriods))
+	for _, lp := range leaderboardPeriods {
+		tab := fmt.Sprintf(" %s ", lp)
+		if lp == period {
+			tab = theme().blockSelected.Render(tab)
+		}
+		tabs = append(tabs, tab)
+	}
+
+	lines := []string{
+		lipgloss.JoinHorizontal(lipgloss.Top, tabs...),
+		fmt.Sprintf("sorted by %s", sortBy),
+		"",
+		fmt.Sprintf("%4s  %-16s %5s %5s %5s %7s", "#", "player", "games", "wins", "best", "rate"),
+	}
+
+	rank := 0
+	for i, e := range board {
+		if e.Fingerprint == p.user {
+			rank = i + 1
+		}
+		if i >= leaderboardSize {
+			continue
+		}
+
+		line := fmt.Sprintf("%4d  %-16s %5d %5d %5d %7.2f", i+1, p.app.DisplayName(e.Fingerprint), e.Games, e.Wins, e.BestScore, e.Rate())
+		if e.Fingerprint == p.user {
+			line = theme().blockHovered.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	if len(board) == 0 {
+		lines = append(lines, "no matches yet")
+	}
+
+	lines = append(lines, "")
+	if rank != 0 {
+		lines = append(lines, fmt.Sprintf("your rank: #%d of %d", rank, len(board)))
+	} else {
+		lines = append(lines, "you have not played in this period")
+	}
+
+	lines = append(lines, "", p.help.ShortHelpView([]key.Binding{
+		p.keymap.period,
+		p.keymap.sort,
+		p.keymap.back,
+	}))
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
+}
+
+// QueuePage is shown while quick match looks for an opponent.
+type QueuePage struct {
+	app  *App
+	user string
+
+	height int
+	width  int
+
+	back key.Binding
+	help help.Model
+}
+
+func NewQueuePage(height, width int) *QueuePage {
+	return &QueuePage{
+		height: height,
+		width:  width,
+		back:   key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "cancel")),
+		help:   help.New(),
+	}
+}
+
+func (p *QueuePage) Init() tea.Cmd {
+	return tickCmd()
+}
+
+func (p *QueuePage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		p.height = msg.Height
+		p.width = msg.Width
+	case tickMsg:
+		return p, tickCmd()
+	case MatchFound:
+		return p, startGame(msg.room)
+	case tea.KeyMsg:
+		switch {
+		case msg.String() == "ctrl+c":
+			p.app.matchmaker.Dequeue(p.user)
+			return p, tea.Quit
+		case key.Matches(msg, p.back):
+			p.app.matchmaker.Dequeue(p.user)
+			page := NewRoomPage(p.height, p.width, p.app)
+			return p, func() tea.Msg {
+				return GotoRoute{route: StaticRoute{Model: page}}
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *QueuePage) View() string {
+	lines := []string{
+		"Looking for an opponent...",
+		"",
+		fmt.Sprintf("your rating: %.0f", p.app.Rating(p.user)),
+	}
+	if e, exists := p.app.matchmaker.Entry(p.user); exists {
+		now := time.Now()
+		lines = append(lines,
+			fmt.Sprintf("waiting: %s", now.Sub(e.since).Truncate(time.Second)),
+			fmt.Sprintf("accepting opponents within ±%.0f", e.allowedGap(now)),
+		)
+	}
+	lines = append(lines, "", p.help.ShortHelpView([]key.Binding{p.back}))
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
+}
+
+// SessionChoicePage asks a key connecting while it has a session whether to
+// take over or to attach as one more view of the same player.
+type SessionChoicePage struct {
+	app     *App
+	user    string
+	session *Session
+	// invite code the session was started with, empty if none
+	joinCode string
+
+	height int
+	width  int
+
+	takeover key.Binding
+	attach   key.Binding
+	quit     key.Binding
+	help     help.Model
+}
+
+func NewSessionChoicePage(session *Session, joinCode string) *SessionChoicePage {
+	return &SessionChoicePage{
+		session:  session,
+		joinCode: joinCode,
+		takeover: key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "take over")),
+		attach:   key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "attach")),
+		quit:     key.NewBinding(key.WithKeys("q", "esc", "ctrl+c"), key.WithHelp("q", "quit")),
+		help:     help.New(),
+	}
+}
+
+func (p *SessionChoicePage) Init() tea.Cmd {
+	return nil
+}
+
+func (p *SessionChoicePage) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		p.height = msg.Height
+		p.width = msg.Width
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, p.takeover):
+			p.app.TakeOver(p.session)
+			return p, p.land()
+		case key.Matches(msg, p.attach):
+			return p, p.land()
+		case key.Matches(msg, p.quit):
+			return p, tea.Quit
+		}
+	}
+
+	return p, nil
+}
+
+// land routes to where a fresh session of the player would start.
+func (p *SessionChoicePage) land() tea.Cmd {
+	page := p.app.landing(p.user, p.joinCode)
+	return func() tea.Msg {
+		return GotoRoute{route: StaticRoute{Model: page}}
+	}
+}
+
+func (p *SessionChoicePage) View() string {
+	lines := []string{
+		"This key is already signed in from another session.",
+		"",
+		"Take over to close the other session, or attach to play",
+		"the same player from both.",
+		"",
+		p.help.ShortHelpView([]key.Binding{p.takeover, p.attach, p.quit}),
+	}
+
+	return lipgloss.Place(
+		p.width,
+		p.height,
+		lipgloss.Center,
+		lipgloss.Center,
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	)
 }
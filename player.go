@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Player is the profile of a user, keyed by the fingerprint of its SSH key.
+type Player struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	GamesPlayed int       `json:"games_played"`
+	Wins        int       `json:"wins"`
+	Losses      int       `json:"losses"`
+	BestScore   int       `json:"best_score"`
+	Rating      float64   `json:"rating"`
+	// best practice score per operator set
+	PracticeBests map[string]int `json:"practice_bests,omitempty"`
+}
+
+var playerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{2,16}$`)
+
+func ValidatePlayerName(name string) error {
+	if !playerNamePattern.MatchString(name) {
+		return fmt.Errorf("name must be 2-16 letters, digits, '_' or '-'")
+	}
+	return nil
+}
+
+// Record adds the outcome of a match to the profile.
+func (p *Player) Record(score int, won, lost bool) {
+	p.GamesPlayed++
+	if won {
+		p.Wins++
+	}
+	if lost {
+		p.Losses++
+	}
+	if score > p.BestScore {
+		p.BestScore = score
+	}
+}
+
+// RecordPractice keeps score as the personal best of key if it beats the
+// previous one, and reports whether it did.
+func (p *Player) RecordPractice(key string, score int) bool {
+	if p.PracticeBests == nil {
+		p.PracticeBests = make(map[string]int)
+	}
+	if best, exists := p.PracticeBests[key]; exists && score <= best {
+		return false
+	}
+	p.PracticeBests[key] = score
+	return true
+}
+
+type PlayerRepository interface {
+	Find(fingerprint string) (Player, bool)
+	Create(fingerprint, name string) (Player, error)
+	Update(fingerprint string, updater func(*Player)) error
+	List() []Player
+}
+
+// JSONPlayerRepository keeps players in memory and writes all of them to a
+// JSON file on every change.
+type JSONPlayerRepository struct {
+	path string
+
+	mu      sync.RWMutex
+	players map[string]*Player
+}
+
+func NewJSONPlayerRepository(path string) (*JSONPlayerRepository, error) {
+	r := &JSONPlayerRepository{
+		path:    path,
+		players: make(map[string]*Player),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read players: %w", err)
+	}
+
+	players := make([]*Player, 0)
+	if err := json.Unmarshal(data, &players); err != nil {
+		return nil, fmt.Errorf("failed to parse players: %w", err)
+	}
+	for _, p := range players {
+		// profiles saved before ratings existed start at the initial rating
+		if p.Rating == 0 {
+			p.Rating = initialRating
+		}
+		r.players[p.Fingerprint] = p
+	}
+
+	return r, nil
+}
+
+func (r *JSONPlayerRepository) Find(fingerprint string) (Player, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, exists := r.players[fingerprint]
+	if !exists {
+		return Player{}, false
+	}
+	return *p, true
+}
+
+func (r *JSONPlayerRepository) Create(fingerprint, name string) (Player, error) {
+	if err := ValidatePlayerName(name); err != nil {
+		return Player{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.players[fingerprint]; exists {
+		return Player{}, fmt.Errorf("player %s exists", fingerprint)
+	}
+	for _, p := range r.players {
+		if strings.EqualFold(p.Name, name) {
+			return Player{}, fmt.Errorf("name %s is taken", name)
+		}
+	}
+
+	p := &Player{
+		Fingerprint: fingerprint,
+		Name:        name,
+		CreatedAt:   time.Now(),
+		Rating:      initialRating,
+	}
+	r.players[fingerprint] = p
+	if err := r.save(); err != nil {
+		delete(r.players, fingerprint)
+		return Player{}, err
+	}
+
+	return *p, nil
+}
+
+func (r *JSONPlayerRepository) Update(fingerprint string, updater func(*Player)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, exists := r.players[fingerprint]
+	if !exists {
+		return fmt.Errorf("player %s not exists", fingerprint)
+	}
+	updater(p)
+
+	return r.save()
+}
+
+func (r *JSONPlayerRepository) List() []Player {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	players := make([]Player, 0, len(r.players))
+	for _, p := range r.players {
+		players = append(players, *p)
+	}
+	return players
+}
+
+// save writes every player to r.path, r.mu must be held.
+func (r *JSONPlayerRepository) save() error {
+	players := make([]*Player, 0, len(r.players))
+	for _, p := range r.players {
+		players = append(players, p)
+	}
+
+	data, err := json.MarshalIndent(players, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode players: %w", err)
+	}
+
+	return writeFileAtomic(r.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it,
+// so a crash never leaves a half-written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts events per key within a sliding window. The limit is
+// given on every call so it follows config reloads.
+type RateLimiter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window: window,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow reports whether key has less than limit events within the window and
+// records the event if so, a limit of 0 allows everything.
+func (rl *RateLimiter) Allow(key string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// forget keys gone quiet, so the map does not grow with every address
+	if now.Sub(rl.lastSweep) > rl.window {
+		for k, events := range rl.events {
+			if len(rl.recent(events, now)) == 0 {
+				delete(rl.events, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	events := rl.recent(rl.events[key], now)
+	if len(events) >= limit {
+		rl.events[key] = events
+		return false
+	}
+	rl.events[key] = append(events, now)
+	return true
+}
+
+// recent drops events older than the window, events are in time order.
+func (rl *RateLimiter) recent(events []time.Time, now time.Time) []time.Time {
+	for i, t := range events {
+		if now.Sub(t) < rl.window {
+			return events[i:]
+		}
+	}
+	return events[:0]
+}
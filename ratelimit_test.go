@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWindow(t *testing.T) {
+	rl := NewRateLimiter(time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !rl.Allow("a", 3, now.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("event %d denied within the limit", i)
+		}
+	}
+	if rl.Allow("a", 3, now.Add(10*time.Second)) {
+		t.Error("event over the limit allowed")
+	}
+	if !rl.Allow("b", 3, now.Add(10*time.Second)) {
+		t.Error("limit of one key applied to another")
+	}
+	// the first event leaves the window, making room for one more
+	if !rl.Allow("a", 3, now.Add(time.Minute)) {
+		t.Error("event denied after the window moved on")
+	}
+	if rl.Allow("a", 3, now.Add(time.Minute+500*time.Millisecond)) {
+		t.Error("event over the limit allowed after the window moved on")
+	}
+}
+
+func TestRateLimiterNoLimit(t *testing.T) {
+	rl := NewRateLimiter(time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		if !rl.Allow("a", 0, now) {
+			t.Fatal("limit 0 denied an event")
+		}
+	}
+	if len(rl.events) != 0 {
+		t.Error("limit 0 recorded events")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := NewRateLimiter(time.Minute)
+	now := time.Now()
+
+	rl.Allow("a", 1, now)
+	rl.Allow("b", 1, now.Add(30*time.Second))
+	rl.Allow("c", 1, now.Add(2*time.Minute))
+
+	if _, exists := rl.events["a"]; exists {
+		t.Error("quiet key not swept")
+	}
+	if _, exists := rl.events["c"]; !exists {
+		t.Error("new key missing")
+	}
+}
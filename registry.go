@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Registry owns sessions, rooms and tables of the server, it is safe for
+// concurrent use.
+//
+// Locks are always acquired in the order mu -> Room.mu -> the repository
+// locks -> sessionsMu. Broadcasting takes mu and Room.mu itself, so it must
+// happen with no registry or room lock held.
+type Registry struct {
+	// mu guards playerToRoom and spectatorToRoom, and makes joining and
+	// leaving a room atomic together with its table
+	mu              sync.Mutex
+	playerToRoom    map[string]*Room
+	spectatorToRoom map[string]*Room
+	roomRepo        RoomRepository
+	tableRepo       ArithmeticTableRepository
+	// grace windows of players who lost their connection during a match
+	away map[string]*time.Timer
+
+	sessionsMu sync.RWMutex
+	// a user has more than one session when further ones attach as extra
+	// views
+	sessions map[string][]*Session
+}
+
+// Session is one connection of a user.
+type Session struct {
+	user string
+	prog *tea.Program
+	// set while the session looks at the room list, guarded by
+	// Registry.sessionsMu
+	watchingRooms bool
+}
+
+// Sessions are the sessions of a user, messages are sent to every one.
+type Sessions []*Session
+
+func (ss Sessions) Send(msg tea.Msg) {
+	for _, s := range ss {
+		s.prog.Send(msg)
+	}
+}
+
+func NewRegistry(maxRooms int) *Registry {
+	return &Registry{
+		playerToRoom:    make(map[string]*Room),
+		spectatorToRoom: make(map[string]*Room),
+		away:            make(map[string]*time.Timer),
+		roomRepo:        NewInMemoryRoomRepository(maxRooms),
+		tableRepo:       NewInMemoryArithmeticTableRepository(),
+		sessions:        make(map[string][]*Session),
+	}
+}
+
+// Session returns the sessions of user.
+func (reg *Registry) Session(user string) (Sessions, bool) {
+	reg.sessionsMu.RLock()
+	defer reg.sessionsMu.RUnlock()
+
+	sessions, exists := reg.sessions[user]
+	return append(Sessions(nil), sessions...), exists
+}
+
+// allSessions returns every session.
+func (reg *Registry) allSessions() []*tea.Program {
+	reg.sessionsMu.RLock()
+	defer reg.sessionsMu.RUnlock()
+
+	progs := make([]*tea.Program, 0, len(reg.sessions))
+	for _, sessions := range reg.sessions {
+		for _, s := range sessions {
+			progs = append(progs, s.prog)
+		}
+	}
+	return progs
+}
+
+// SessionCount returns the number of connections.
+func (reg *Registry) SessionCount() int {
+	reg.sessionsMu.RLock()
+	defer reg.sessionsMu.RUnlock()
+
+	n := 0
+	for _, sessions := range reg.sessions {
+		n += len(sessions)
+	}
+	return n
+}
+
+func (reg *Registry) AddSession(s *Session) {
+	reg.sessionsMu.Lock()
+	defer reg.sessionsMu.Unlock()
+
+	reg.sessions[s.user] = append(reg.sessions[s.user], s)
+}
+
+// RemoveSession removes s and reports whether it was the last session of its
+// user.
+func (reg *Registry) RemoveSession(s *Session) bool {
+	reg.sessionsMu.Lock()
+	defer reg.sessionsMu.Unlock()
+
+	sessions := reg.sessions[s.user]
+	for i := range sessions {
+		if sessions[i] == s {
+			sessions = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(reg.sessions, s.user)
+		return true
+	}
+	reg.sessions[s.user] = sessions
+	return false
+}
+
+// WatchRooms sets whether s receives changes of the room list.
+func (reg *Registry) WatchRooms(s *Session, watching bool) {
+	reg.sessionsMu.Lock()
+	defer reg.sessionsMu.Unlock()
+
+	s.watchingRooms = watching
+}
+
+// roomWatcherSessions returns sessions looking at the room list.
+func (reg *Registry) roomWatcherSessions() []*tea.Program {
+	reg.sessionsMu.RLock()
+	defer reg.sessionsMu.RUnlock()
+
+	progs := make([]*tea.Program, 0)
+	for _, sessions := range reg.sessions {
+		for _, s := range sessions {
+			if s.watchingRooms {
+				progs = append(progs, s.prog)
+			}
+		}
+	}
+	return progs
+}
+
+func (reg *Registry) RoomOf(player string) (*Room, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	r, exists := reg.playerToRoom[player]
+	return r, exists
+}
+
+// IsAway reports whether player has a grace window running.
+func (reg *Registry) IsAway(player string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	_, exists := reg.away[player]
+	return exists
+}
+
+func (reg *Registry) SpectatedRoom(user string) (*Room, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	r, exists := reg.spectatorToRoom[user]
+	return r, exists
+}
+
+// spectate subscribes user to the messages of r without taking a slot.
+func (reg *Registry) spectate(user string, r *Room) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, exists := reg.playerToRoom[user]; exists {
+		return fmt.Errorf("player %s is already in a room", user)
+	}
+	if _, exists := reg.spectatorToRoom[user]; exists {
+		return fmt.Errorf("user %s is already spectating", user)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if reg.roomRepo.Find(r.id) != r {
+		return fmt.Errorf("room %d not exists", r.id)
+	}
+
+	r.spectators = append(r.spectators, user)
+	reg.spectatorToRoom[user] = r
+	return nil
+}
+
+// join adds player to r and creates its table, it reports whether the room
+// became full.
+func (reg *Registry) join(player string, r *Room) (index int, full bool, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, exists := reg.playerToRoom[player]; exists {
+		return 0, false, fmt.Errorf("player %s is already in a room", player)
+	}
+	if _, exists := reg.spectatorToRoom[player]; exists {
+		return 0, false, fmt.Errorf("player %s is spectating", player)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != RoomWaiting || r.isFull() {
+		return 0, false, fmt.Errorf("room %d is not open", r.id)
+	}
+	if r.banned[player] {
+		return 0, false, fmt.Errorf("player %s was kicked from room %d", player, r.id)
+	}
+
+	if _, err := reg.tableRepo.Create(player, r.seed, r.difficulty, r.operators, r.penalty); err != nil {
+		return 0, false, err
+	}
+
+	reg.playerToRoom[player] = r
+	index = r.join(player)
+	full = r.isFull()
+	if full {
+		r.setStatus(RoomReadyCheck)
+	}
+
+	return index, full, nil
+}
+
+// leave removes player from its room and releases its table, or stops it
+// from spectating. The room is removed once it has no players, its
+// spectators are returned as orphans. It also reports whether the room went
+// back to waiting for players.
+func (reg *Registry) leave(player string) (r *Room, backToWaiting bool, orphans []string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r, exists := reg.spectatorToRoom[player]; exists {
+		delete(reg.spectatorToRoom, player)
+		r.mu.Lock()
+		r.removeSpectator(player)
+		r.mu.Unlock()
+		return nil, false, nil
+	}
+
+	reg.tableRepo.RemoveByPlayer(player)
+	reg.takeAway(player)
+
+	r, exists := reg.playerToRoom[player]
+	if !exists {
+		return nil, false, nil
+	}
+	delete(reg.playerToRoom, player)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removePlayer(player)
+	if r.host == player {
+		r.passHost()
+	}
+	if len(r.players) == 0 {
+		reg.roomRepo.Remove(r.id)
+		orphans = r.spectators
+		r.spectators = nil
+		for _, s := range orphans {
+			delete(reg.spectatorToRoom, s)
+		}
+	} else if r.status == RoomReadyCheck || r.status == RoomCountdown {
+		r.ready = make(map[string]bool)
+		r.setStatus(RoomWaiting)
+		backToWaiting = true
+	}
+
+	return r, backToWaiting, orphans
+}
+
+// disconnect keeps the slot and table of player in a running match for grace,
+// expire is called once the window is over. It reports false if the player
+// is not in a running match.
+func (reg *Registry) disconnect(player string, grace time.Duration, expire func()) (*Room, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	r, exists := reg.playerToRoom[player]
+	if !exists || grace <= 0 {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != RoomCountdown && r.status != RoomPlaying {
+		return nil, false
+	}
+	if _, exists := reg.away[player]; exists {
+		return r, true
+	}
+	r.away[player] = true
+	reg.away[player] = time.AfterFunc(grace, expire)
+	return r, true
+}
+
+// reconnect gives player its slot back, it reports false if the player has
+// no grace window running.
+func (reg *Registry) reconnect(player string) (*Room, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if !reg.takeAway(player) {
+		return nil, false
+	}
+	r := reg.playerToRoom[player]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.away, player)
+	return r, true
+}
+
+// expireAway ends the grace window of player, it reports false if the player
+// reconnected or left meanwhile.
+func (reg *Registry) expireAway(player string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	return reg.takeAway(player)
+}
+
+// takeAway stops the grace window of player, reg.mu must be held.
+func (reg *Registry) takeAway(player string) bool {
+	timer, exists := reg.away[player]
+	if !exists {
+		return false
+	}
+	timer.Stop()
+	delete(reg.away, player)
+	return true
+}
+
+// rematch records that player wants to play again. Once every player agrees
+// the tables are regenerated and the countdown round is returned.
+func (reg *Registry) rematch(player string) (r *Room, round int, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	r, exists := reg.playerToRoom[player]
+	if !exists {
+		return nil, 0, fmt.Errorf("player %s is not in a room", player)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != RoomFinished {
+		return r, 0, fmt.Errorf("room %d is not finished", r.id)
+	}
+	if !r.requestRematch(player) {
+		return r, 0, nil
+	}
+
+	r.reset()
+	for _, p := range r.players {
+		reg.tableRepo.RemoveByPlayer(p)
+		if _, err := reg.tableRepo.Create(p, r.seed, r.difficulty, r.operators, r.penalty); err != nil {
+			return r, 0, err
+		}
+	}
+
+	return r, r.beginCountdown(), nil
+}
+
+// kick removes target from the room of host and bans it, only the host of a
+// room may kick.
+func (reg *Registry) kick(host, target string) (*Room, error) {
+	reg.mu.Lock()
+	r, exists := reg.playerToRoom[host]
+	reg.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("player %s is not in a room", host)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.host != host {
+		return nil, fmt.Errorf("player %s is not the host of room %d", host, r.id)
+	}
+	if r.status == RoomPlaying {
+		return nil, fmt.Errorf("room %d is playing", r.id)
+	}
+	if host == target {
+		return nil, fmt.Errorf("host can not kick itself")
+	}
+	for _, p := range r.players {
+		if p == target {
+			r.banned[target] = true
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("player %s is not in room %d", target, r.id)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRegistryConcurrentUse simulates many players connecting, joining,
+// leaving and disconnecting at once, run it with -race.
+func TestRegistryConcurrentUse(t *testing.T) {
+	const (
+		players = 32
+		rounds  = 50
+	)
+
+	reg := NewRegistry(players)
+
+	rooms := make([]*Room, 0, players/2)
+	for i := 0; i < players/2; i++ {
+		r, err := reg.roomRepo.Create(RoomOptions{
+			Mode:       RoomModeCustom,
+			Difficulty: DifficultyNormal,
+			Operators:  DifficultyNormal.Operators,
+			Capacity:   maxRoomCapacity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rooms = append(rooms, r)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < players; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			player := fmt.Sprintf("player-%d", i)
+			for n := 0; n < rounds; n++ {
+				// every player connects twice, like an attached view
+				sessions := []*Session{{user: player}, {user: player}}
+				for _, s := range sessions {
+					reg.AddSession(s)
+				}
+				reg.Session(player)
+
+				r := rooms[(i+n)%len(rooms)]
+				if _, _, err := reg.join(player, r); err == nil {
+					r.Snapshot()
+					reg.RoomOf(player)
+					// the room is not running, so the player leaves at once
+					if _, away := reg.disconnect(player, time.Minute, func() {}); away {
+						t.Error("player away from a waiting room")
+					}
+					reg.reconnect(player)
+					reg.leave(player)
+				}
+
+				if reg.RemoveSession(sessions[0]) {
+					t.Error("first session reported as last")
+				}
+				if !reg.RemoveSession(sessions[1]) {
+					t.Error("second session not reported as last")
+				}
+			}
+		}(i)
+	}
+
+	// rooms come and go while players move around
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for n := 0; n < rounds; n++ {
+			r, err := reg.roomRepo.Create(RoomOptions{Mode: RoomModeCustom, Capacity: minRoomCapacity})
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			reg.roomRepo.List()
+			reg.roomRepo.Find(r.id)
+			reg.roomRepo.FindByCode(r.code)
+			reg.roomRepo.Remove(r.id)
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < players; i++ {
+		player := fmt.Sprintf("player-%d", i)
+		if _, exists := reg.Session(player); exists {
+			t.Errorf("%s still has a session", player)
+		}
+		if _, exists := reg.RoomOf(player); exists {
+			t.Errorf("%s still in a room", player)
+		}
+		if reg.tableRepo.FindByPlayer(player) != nil {
+			t.Errorf("%s still has a table", player)
+		}
+	}
+}
+
+// TestRegistryJoinFullRoom checks that concurrent joins never overfill a
+// room.
+func TestRegistryJoinFullRoom(t *testing.T) {
+	const capacity = 3
+
+	reg := NewRegistry(1)
+	r, err := reg.roomRepo.Create(RoomOptions{
+		Mode:       RoomModeCustom,
+		Difficulty: DifficultyNormal,
+		Operators:  DifficultyNormal.Operators,
+		Capacity:   capacity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined := 0
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if _, _, err := reg.join(fmt.Sprintf("player-%d", i), r); err == nil {
+				mu.Lock()
+				joined++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if joined != capacity {
+		t.Errorf("%d players joined, want %d", joined, capacity)
+	}
+	if s := r.Snapshot(); s.status != RoomReadyCheck {
+		t.Errorf("room is %s, want %s", s.status, RoomReadyCheck)
+	}
+}
+
+// TestRegistryAwayPlayer checks the grace window of a player dropped from a
+// running match.
+func TestRegistryAwayPlayer(t *testing.T) {
+	reg := NewRegistry(1)
+	r, err := reg.roomRepo.Create(RoomOptions{
+		Mode:       RoomModeCustom,
+		Difficulty: DifficultyNormal,
+		Operators:  DifficultyNormal.Operators,
+		Capacity:   2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b"} {
+		if _, _, err := reg.join(p, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.mu.Lock()
+	r.beginCountdown()
+	r.mu.Unlock()
+
+	if _, away := reg.disconnect("a", time.Minute, func() {}); !away {
+		t.Fatal("player not away from a running match")
+	}
+	if !r.Snapshot().away["a"] {
+		t.Error("room does not show the player away")
+	}
+	if _, back := reg.reconnect("a"); !back {
+		t.Fatal("player could not reconnect")
+	}
+	if r.Snapshot().away["a"] {
+		t.Error("room still shows the player away")
+	}
+	if reg.expireAway("a") {
+		t.Error("grace window still running after reconnecting")
+	}
+
+	expired := make(chan struct{})
+	reg.disconnect("a", time.Millisecond, func() {
+		if reg.expireAway("a") {
+			reg.leave("a")
+		}
+		close(expired)
+	})
+	<-expired
+	if _, exists := reg.RoomOf("a"); exists {
+		t.Error("player still in the room after the grace window")
+	}
+	if reg.tableRepo.FindByPlayer("a") != nil {
+		t.Error("table kept after the grace window")
+	}
+}
+
+func TestRoomRepositoryLimit(t *testing.T) {
+	repo := NewInMemoryRoomRepository(1)
+	r, err := repo.Create(RoomOptions{Mode: RoomModeCustom, Capacity: minRoomCapacity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(RoomOptions{Mode: RoomModeCustom, Capacity: minRoomCapacity}); err == nil {
+		t.Error("created a room over the limit")
+	}
+	repo.Remove(r.id)
+	if _, err := repo.Create(RoomOptions{Mode: RoomModeCustom, Capacity: minRoomCapacity}); err != nil {
+		t.Error(err)
+	}
+}
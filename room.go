@@ -1,71 +1,511 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type RoomStatus int
+
+const (
+	RoomWaiting RoomStatus = iota
+	RoomReadyCheck
+	RoomCountdown
+	RoomPlaying
+	RoomFinished
+)
+
+func (s RoomStatus) String() string {
+	switch s {
+	case RoomWaiting:
+		return "waiting"
+	case RoomReadyCheck:
+		return "ready-check"
+	case RoomCountdown:
+		return "countdown"
+	case RoomPlaying:
+		return "playing"
+	case RoomFinished:
+		return "finished"
+	default:
+		return "unknown"
+	}
+}
+
+// RoomMode tells how a room was made.
+type RoomMode int
+
+const (
+	RoomModeCustom RoomMode = iota
+	RoomModeQuickMatch
+	// practice rooms have a single player and are not listed nor rated
+	RoomModePractice
+)
+
+func (m RoomMode) String() string {
+	switch m {
+	case RoomModeQuickMatch:
+		return "quick match"
+	case RoomModePractice:
+		return "practice"
+	default:
+		return "custom"
+	}
+}
+
+type RoomEventKind int
+
+const (
+	RoomCreated RoomEventKind = iota
+	RoomPlayerJoined
+	RoomPlayerLeft
+	RoomStatusUpdated
+	RoomRemoved
+)
+
+func (k RoomEventKind) String() string {
+	switch k {
+	case RoomCreated:
+		return "created"
+	case RoomPlayerJoined:
+		return "player joined"
+	case RoomPlayerLeft:
+		return "player left"
+	case RoomStatusUpdated:
+		return "status changed"
+	case RoomRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// RoomEvent tells listeners of a RoomRepository that a room changed.
+type RoomEvent struct {
+	Kind RoomEventKind
+	Room *Room
+}
+
+// RoomOptions are the settings a room is created with.
+type RoomOptions struct {
+	Name       string
+	Mode       RoomMode
+	Difficulty Difficulty
+	Operators  OperatorSet
+	Capacity   int
+	// penalty of every table in the room
+	Penalty Penalty
+	// length of every match in the room
+	Duration time.Duration
+	// private rooms are not listed, they are joined by their invite code
+	Private bool
+}
 
 type Room struct {
-	id      int
+	// assigned by the repository
+	id        int
+	code      string
+	createdAt time.Time
+
+	name       string
+	mode       RoomMode
+	difficulty Difficulty
+	operators  OperatorSet
+	penalty    Penalty
+	duration   time.Duration
+	capacity   int
+	private    bool
+	// notify publishes events of the room to the repository listeners, it
+	// must not lock the room
+	notify func(RoomEvent)
+
+	// mu guards the fields below
+	mu      sync.Mutex
 	players []string
+	// sessions watching the match, they do not count towards capacity
+	spectators []string
+	// the player allowed to kick others, passed on when it leaves
+	host string
+	// players kicked by the host, they can not join again
+	banned map[string]bool
+	// players whose connection dropped during a match, their slot is kept
+	// until they reconnect or the grace window is over
+	away map[string]bool
+	// seed of every table in this room, a match can be replayed from it
+	seed int64
+	// players who want to play again after the match
+	rematch map[string]bool
+
+	status RoomStatus
+	// players who pressed ready during the ready-check
+	ready     map[string]bool
+	countdown int
+	// incremented every time a countdown starts, so a stale countdown or
+	// clock can tell it has been superseded
+	round int
+	// the match clock shared by every player in the room
+	startedAt time.Time
+	deadline  time.Time
+}
+
+// RoomSnapshot is a copy of the room state which can be read without locking.
+type RoomSnapshot struct {
+	players   []string
+	away      map[string]bool
+	status    RoomStatus
+	ready     map[string]bool
+	countdown int
+	startedAt time.Time
+	deadline  time.Time
+	seed      int64
+}
+
+func (r *Room) Snapshot() RoomSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ready := make(map[string]bool, len(r.ready))
+	for p, v := range r.ready {
+		ready[p] = v
+	}
+	away := make(map[string]bool, len(r.away))
+	for p, v := range r.away {
+		away[p] = v
+	}
+
+	return RoomSnapshot{
+		players:   append([]string(nil), r.players...),
+		away:      away,
+		status:    r.status,
+		ready:     ready,
+		countdown: r.countdown,
+		startedAt: r.startedAt,
+		deadline:  r.deadline,
+		seed:      r.seed,
+	}
+}
+
+func (r *Room) Status() RoomStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+func (r *Room) Host() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.host
+}
+
+func (r *Room) Players() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.players...)
+}
+
+func (r *Room) Spectators() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.spectators...)
+}
+
+// Audience returns everyone receiving messages of the room, players and
+// spectators.
+func (r *Room) Audience() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	audience := make([]string, 0, len(r.players)+len(r.spectators))
+	audience = append(audience, r.players...)
+	return append(audience, r.spectators...)
+}
+
+// practiceKey identifies the settings personal bests are kept for.
+func (r *Room) practiceKey() string {
+	return fmt.Sprintf("%s %s", r.difficulty, r.operators)
+}
+
+func (r *Room) practice() bool {
+	return r.mode == RoomModePractice
+}
+
+// listed reports whether the room shows up in the room list.
+func (r *Room) listed() bool {
+	return !r.private && !r.practice()
 }
 
-func (r *Room) RemovePlayer(player string) error {
-	found := false
-	n := len(r.players)
+// The methods below require r.mu to be held.
+
+func (r *Room) publish(kind RoomEventKind) {
+	if r.notify != nil {
+		r.notify(RoomEvent{Kind: kind, Room: r})
+	}
+}
+
+func (r *Room) removePlayer(player string) error {
 	for i, p := range r.players {
 		if player == p {
-			r.players[i] = r.players[n-1]
-			found = true
-			break
+			r.players = append(r.players[:i], r.players[i+1:]...)
+			delete(r.rematch, player)
+			delete(r.ready, player)
+			delete(r.away, player)
+			r.publish(RoomPlayerLeft)
+			return nil
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("player %s not found", player)
-	}
+	return fmt.Errorf("player %s not found", player)
+}
 
-	r.players = r.players[:n-1]
-	return nil
+func (r *Room) removeSpectator(user string) {
+	for i, s := range r.spectators {
+		if user == s {
+			r.spectators = append(r.spectators[:i], r.spectators[i+1:]...)
+			return
+		}
+	}
 }
 
-func (r *Room) Join(player string) int {
+func (r *Room) join(player string) int {
+	if len(r.players) == 0 {
+		r.host = player
+	}
 	r.players = append(r.players, player)
+	r.publish(RoomPlayerJoined)
 	return len(r.players) - 1
 }
 
+// passHost gives the room to the first remaining human, or any player if
+// only bots are left.
+func (r *Room) passHost() {
+	if len(r.players) == 0 {
+		r.host = ""
+		return
+	}
+	r.host = r.players[0]
+	for _, p := range r.players {
+		if !isBot(p) {
+			r.host = p
+			return
+		}
+	}
+}
+
+func (r *Room) isFull() bool {
+	return len(r.players) >= r.capacity
+}
+
+// inRound reports whether the room is still in status during round.
+func (r *Room) inRound(status RoomStatus, round int) bool {
+	return r.status == status && r.round == round
+}
+
+func (r *Room) setStatus(status RoomStatus) {
+	log.Infof("room %d: %s -> %s", r.id, r.status, status)
+	r.status = status
+	r.publish(RoomStatusUpdated)
+}
+
+func (r *Room) statusChanged() RoomStatusChanged {
+	return RoomStatusChanged{
+		status:    r.status,
+		startedAt: r.startedAt,
+		deadline:  r.deadline,
+		seed:      r.seed,
+	}
+}
+
+// setReady marks player as ready and reports whether the room is full and
+// every player in it is ready.
+func (r *Room) setReady(player string) bool {
+	r.ready[player] = true
+	if !r.isFull() {
+		return false
+	}
+	for _, p := range r.players {
+		if !r.ready[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestRematch marks player as willing to play again and reports whether
+// every player in the room agrees.
+func (r *Room) requestRematch(player string) bool {
+	r.rematch[player] = true
+	for _, p := range r.players {
+		if !r.rematch[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// reset prepares the room for a new match with a fresh seed.
+func (r *Room) reset() {
+	r.rematch = make(map[string]bool)
+	r.ready = make(map[string]bool)
+	r.seed = time.Now().UnixNano()
+	log.Infof("room %d: new match, seed: %d", r.id, r.seed)
+}
+
+// beginCountdown moves the room into a new countdown round and returns it.
+func (r *Room) beginCountdown() int {
+	r.round++
+	r.setStatus(RoomCountdown)
+	return r.round
+}
+
 type RoomRepository interface {
-	Create(id int) (*Room, error)
+	// Create adds a room with a new unique id.
+	Create(opts RoomOptions) (*Room, error)
+	// Subscribe calls listener on every change of a room, listener must not
+	// lock the room.
+	Subscribe(listener func(RoomEvent))
 	Find(id int) *Room
+	FindByCode(code string) *Room
 	List() []*Room
 	Remove(id int) error
 }
 
 type InMemoryRoomRepository struct {
-	rooms map[int]*Room
+	mu     sync.RWMutex
+	rooms  map[int]*Room
+	codes  map[string]*Room
+	nextID int
 
 	roomArr []*Room
+
+	// maximum number of rooms existing at once
+	maxRooms int
+
+	listenersMu sync.RWMutex
+	listeners   []func(RoomEvent)
 }
 
-func NewInMemoryRoomRepository() *InMemoryRoomRepository {
+func NewInMemoryRoomRepository(maxRooms int) *InMemoryRoomRepository {
 	return &InMemoryRoomRepository{
-		rooms: make(map[int]*Room),
+		rooms:    make(map[int]*Room),
+		codes:    make(map[string]*Room),
+		nextID:   1,
+		maxRooms: maxRooms,
 	}
 }
 
-func (rr *InMemoryRoomRepository) Create(id int) (*Room, error) {
-	if _, exists := rr.rooms[id]; exists {
-		return nil, fmt.Errorf("id %d used", id)
+func (rr *InMemoryRoomRepository) Create(opts RoomOptions) (*Room, error) {
+	capacity := opts.Capacity
+	if opts.Mode == RoomModePractice {
+		capacity = 1
+	} else if capacity < minRoomCapacity || capacity > maxRoomCapacity {
+		return nil, fmt.Errorf("capacity %d out of range [%d, %d]", capacity, minRoomCapacity, maxRoomCapacity)
+	}
+
+	rr.mu.Lock()
+
+	if len(rr.rooms) >= rr.maxRooms {
+		rr.mu.Unlock()
+		return nil, fmt.Errorf("too many rooms, try again later")
+	}
+
+	// ids are never reused, so a stale reference can not find a new room
+	id := rr.nextID
+	rr.nextID++
+
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("Room #%d", id)
 	}
 
-	r := &Room{id: id, players: make([]string, 0)}
+	r := &Room{
+		id:         id,
+		code:       rr.newCode(),
+		createdAt:  time.Now(),
+		name:       name,
+		mode:       opts.Mode,
+		difficulty: opts.Difficulty,
+		operators:  opts.Operators,
+		penalty:    opts.Penalty,
+		duration:   opts.Duration,
+		capacity:   capacity,
+		private:    opts.Private,
+		players:    make([]string, 0),
+		seed:       time.Now().UnixNano(),
+		rematch:    make(map[string]bool),
+		status:     RoomWaiting,
+		ready:      make(map[string]bool),
+		banned:     make(map[string]bool),
+		away:       make(map[string]bool),
+		notify:     rr.notify,
+	}
 	rr.rooms[id] = r
+	rr.codes[r.code] = r
 	rr.updateList()
+	rr.mu.Unlock()
+
+	rr.notify(RoomEvent{Kind: RoomCreated, Room: r})
 	return r, nil
 }
 
+func (rr *InMemoryRoomRepository) Subscribe(listener func(RoomEvent)) {
+	rr.listenersMu.Lock()
+	defer rr.listenersMu.Unlock()
+
+	rr.listeners = append(rr.listeners, listener)
+}
+
+// notify calls every listener with e, rr.mu must not be held.
+func (rr *InMemoryRoomRepository) notify(e RoomEvent) {
+	rr.listenersMu.RLock()
+	defer rr.listenersMu.RUnlock()
+
+	for _, listener := range rr.listeners {
+		listener(e)
+	}
+}
+
 func (rr *InMemoryRoomRepository) Find(id int) *Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
 	return rr.rooms[id]
 }
 
+// FindByCode returns the room with the invite code, ignoring case.
+func (rr *InMemoryRoomRepository) FindByCode(code string) *Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	return rr.codes[strings.ToUpper(strings.TrimSpace(code))]
+}
+
+// newCode returns an unused invite code, rr.mu must be held.
+func (rr *InMemoryRoomRepository) newCode() string {
+	code := make([]byte, inviteCodeLength)
+	for {
+		for i := range code {
+			code[i] = inviteCodeAlphabet[rand.Intn(len(inviteCodeAlphabet))]
+		}
+		if _, exists := rr.codes[string(code)]; !exists {
+			return string(code)
+		}
+	}
+}
+
 func (rr *InMemoryRoomRepository) List() []*Room {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
 	return rr.roomArr
 }
 
@@ -74,15 +514,24 @@ func (rr *InMemoryRoomRepository) updateList() {
 	for _, r := range rr.rooms {
 		rs = append(rs, r)
 	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].id < rs[j].id
+	})
 	rr.roomArr = rs
 }
 
 func (rr *InMemoryRoomRepository) Remove(id int) error {
-	if _, exists := rr.rooms[id]; exists {
-		delete(rr.rooms, id)
-		rr.updateList()
-		return nil
-	} else {
+	rr.mu.Lock()
+	r, exists := rr.rooms[id]
+	if !exists {
+		rr.mu.Unlock()
 		return fmt.Errorf("id %d not exists", id)
 	}
+	delete(rr.rooms, id)
+	delete(rr.codes, r.code)
+	rr.updateList()
+	rr.mu.Unlock()
+
+	rr.notify(RoomEvent{Kind: RoomRemoved, Room: r})
+	return nil
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PenaltyMode decides what happens when a player chooses a wrong pair.
+type PenaltyMode string
+
+const (
+	// PenaltyPoints takes points away
+	PenaltyPoints PenaltyMode = "points"
+	// PenaltyLockout ignores choosing blocks for a moment
+	PenaltyLockout PenaltyMode = "lockout"
+)
+
+// Penalty is what choosing a wrong pair costs, rooms take it from the config
+// when they are created.
+type Penalty struct {
+	Mode PenaltyMode `toml:"mode" yaml:"mode"`
+	// points taken away in PenaltyPoints mode
+	Points int `toml:"points" yaml:"points"`
+	// how long choosing blocks is ignored in PenaltyLockout mode
+	Lockout Duration `toml:"lockout" yaml:"lockout"`
+}
+
+// ScoreBreakdown tells where the points of a table come from.
+type ScoreBreakdown struct {
+	Matches     int
+	Mistakes    int
+	BestStreak  int
+	Base        int
+	StreakBonus int
+	SpeedBonus  int
+	Penalty     int
+}
+
+func (b ScoreBreakdown) Total() int {
+	return b.Base + b.StreakBonus + b.SpeedBonus - b.Penalty
+}
+
+func (b ScoreBreakdown) String() string {
+	parts := []string{
+		fmt.Sprintf("%d base", b.Base),
+		fmt.Sprintf("+%d streak", b.StreakBonus),
+		fmt.Sprintf("+%d speed", b.SpeedBonus),
+	}
+	if b.Penalty != 0 {
+		parts = append(parts, fmt.Sprintf("-%d penalty", b.Penalty))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Summary describes the moves behind the score.
+func (b ScoreBreakdown) Summary() string {
+	return fmt.Sprintf("%d matches, %d mistakes, best streak %d", b.Matches, b.Mistakes, b.BestStreak)
+}
+
+// Scorer scores the moves of one table.
+type Scorer struct {
+	penalty   Penalty
+	breakdown ScoreBreakdown
+	streak    int
+	lastMatch time.Time
+	// choosing blocks is ignored until then
+	lockedUntil time.Time
+}
+
+// multiplier grows by one every scoreStreakStep matches in a row.
+func (s *Scorer) multiplier() int {
+	m := 1 + (s.streak-1)/scoreStreakStep
+	if m > scoreMaxMultiplier {
+		m = scoreMaxMultiplier
+	}
+	return m
+}
+
+// match scores a matched pair worth points and returns the points gained.
+func (s *Scorer) match(now time.Time, points int) int {
+	s.streak++
+	if s.streak > s.breakdown.BestStreak {
+		s.breakdown.BestStreak = s.streak
+	}
+
+	streak := points * (s.multiplier() - 1)
+	speed := 0
+	if !s.lastMatch.IsZero() && now.Sub(s.lastMatch) <= scoreSpeedWindow {
+		speed = points
+	}
+	s.lastMatch = now
+
+	s.breakdown.Matches++
+	s.breakdown.Base += points
+	s.breakdown.StreakBonus += streak
+	s.breakdown.SpeedBonus += speed
+	return points + streak + speed
+}
+
+// miss breaks the streak and applies the penalty, it returns the points
+// lost as a negative number.
+func (s *Scorer) miss(now time.Time) int {
+	s.streak = 0
+	s.breakdown.Mistakes++
+
+	switch s.penalty.Mode {
+	case PenaltyLockout:
+		s.lockedUntil = now.Add(s.penalty.Lockout.Duration)
+		return 0
+	default:
+		// the score never drops below zero
+		penalty := s.penalty.Points
+		if total := s.breakdown.Total(); penalty > total {
+			penalty = total
+		}
+		s.breakdown.Penalty += penalty
+		return -penalty
+	}
+}
+
+func (s *Scorer) locked(now time.Time) bool {
+	return now.Before(s.lockedUntil)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScorerMultiplierCap(t *testing.T) {
+	s := Scorer{}
+	now := time.Now()
+	const points = 2
+
+	for streak := 1; streak <= 20; streak++ {
+		// far apart, so no speed bonus
+		now = now.Add(time.Minute)
+		want := 1 + (streak-1)/scoreStreakStep
+		if want > scoreMaxMultiplier {
+			want = scoreMaxMultiplier
+		}
+		if got := s.match(now, points); got != points*want {
+			t.Fatalf("match %d scored %d, want %d", streak, got, points*want)
+		}
+	}
+
+	b := s.breakdown
+	if b.Matches != 20 || b.BestStreak != 20 || b.Base != 20*points {
+		t.Errorf("got %+v, want 20 matches, best streak 20, base %d", b, 20*points)
+	}
+	if b.Total() != b.Base+b.StreakBonus {
+		t.Errorf("total %d does not add up from %+v", b.Total(), b)
+	}
+}
+
+func TestScorerSpeedWindow(t *testing.T) {
+	s := Scorer{}
+	now := time.Now()
+	const points = 2
+
+	if got := s.match(now, points); got != points {
+		t.Fatalf("first match scored %d, want %d", got, points)
+	}
+	now = now.Add(scoreSpeedWindow)
+	if got := s.match(now, points); got != 2*points {
+		t.Fatalf("quick match scored %d, want %d", got, 2*points)
+	}
+
+	// a miss breaks the streak but not the clock of the speed bonus
+	s.miss(now)
+	now = now.Add(scoreSpeedWindow + time.Millisecond)
+	if got := s.match(now, points); got != points {
+		t.Fatalf("slow match scored %d, want %d", got, points)
+	}
+	if s.breakdown.SpeedBonus != points {
+		t.Errorf("got speed bonus %d, want %d", s.breakdown.SpeedBonus, points)
+	}
+}
+
+func TestScorerPenaltyFloor(t *testing.T) {
+	s := Scorer{penalty: Penalty{Mode: PenaltyPoints, Points: 3}}
+	now := time.Now()
+
+	if got := s.miss(now); got != 0 {
+		t.Fatalf("miss at zero scored %d, want 0", got)
+	}
+	s.match(now, 2)
+	if got := s.miss(now); got != -2 {
+		t.Fatalf("miss scored %d, want -2", got)
+	}
+	if total := s.breakdown.Total(); total != 0 {
+		t.Errorf("got total %d, want 0", total)
+	}
+	s.match(now.Add(time.Minute), 5)
+	if got := s.miss(now); got != -3 {
+		t.Fatalf("miss scored %d, want -3", got)
+	}
+	if s.breakdown.Mistakes != 3 || s.streak != 0 {
+		t.Errorf("got %d mistakes and streak %d, want 3 and 0", s.breakdown.Mistakes, s.streak)
+	}
+	if s.locked(now) {
+		t.Error("points penalty locked the table")
+	}
+}
+
+func TestScorerPenaltyLockout(t *testing.T) {
+	s := Scorer{penalty: Penalty{Mode: PenaltyLockout, Points: 3, Lockout: Duration{time.Second}}}
+	now := time.Now()
+	s.match(now, 2)
+
+	if got := s.miss(now); got != 0 {
+		t.Fatalf("lockout miss scored %d, want 0", got)
+	}
+	if !s.locked(now.Add(time.Second - time.Millisecond)) {
+		t.Error("table not locked during the lockout")
+	}
+	if s.locked(now.Add(time.Second)) {
+		t.Error("table still locked after the lockout")
+	}
+	if s.breakdown.Penalty != 0 {
+		t.Errorf("lockout took %d points", s.breakdown.Penalty)
+	}
+}
+
+func TestToggleIgnoredWhileLocked(t *testing.T) {
+	table := NewArithmeticTable(1, DifficultyNormal, DifficultyNormal.Operators)
+	table.scorer.penalty = Penalty{Mode: PenaltyLockout, Lockout: Duration{time.Minute}}
+	go func() {
+		for range table.updateBlockFlagsCh {
+		}
+	}()
+	defer close(table.updateBlockFlagsCh)
+
+	// choose two blocks of different values
+	values := table.Values()
+	a, b := [2]int{0, 0}, [2]int{-1, -1}
+	for i, row := range values {
+		for j, v := range row {
+			if v != values[0][0] && b[0] == -1 {
+				b = [2]int{i, j}
+			}
+		}
+	}
+	if b[0] == -1 {
+		t.Fatal("every block has the same value")
+	}
+	for _, pos := range [][2]int{a, b} {
+		table.mu.Lock()
+		table.hoveredRow, table.hoveredCol = pos[0], pos[1]
+		table.mu.Unlock()
+		table.Toggle()
+	}
+
+	if table.Breakdown().Mistakes != 1 {
+		t.Fatal("wrong pair not counted as a mistake")
+	}
+	table.Toggle()
+	if _, _, selected := table.Selection(); selected {
+		t.Error("locked table selected a block")
+	}
+}